@@ -8,13 +8,16 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ive663/forum/internal/config"
 	"github.com/ive663/forum/internal/delivery"
+	"github.com/ive663/forum/internal/mailer"
 	"github.com/ive663/forum/internal/repository"
 	"github.com/ive663/forum/internal/server"
 	"github.com/ive663/forum/internal/service"
 )
 
 func main() {
+	cfg := config.New()
 	db, err := repository.Init()
 	if err != nil {
 		log.Print(err)
@@ -31,11 +34,19 @@ func main() {
 		return
 	}
 	repositories := repository.NewRepository(db)
-	services := service.NewServices(repositories)
-	handlers := delivery.NewHandler(services)
+	services := service.NewServices(repositories, cfg, mailer.New(cfg.Mail))
+	if err := services.Auth.SeedAdmins(); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := services.Auth.BackfillLoginKeys(); err != nil {
+		log.Print(err)
+		return
+	}
+	handlers := delivery.NewHandler(services, cfg)
 	server := new(server.Server)
 	go func() {
-		if err := server.Start(":8080", handlers.Handlers()); err != nil {
+		if err := server.Start(cfg.Addr, handlers.Handlers()); err != nil {
 			log.Println(err)
 			return
 		}
@@ -46,6 +57,21 @@ func main() {
 			if err := services.Auth.DeleteExpiredSessions(); err != nil {
 				log.Println(err)
 			}
+			if err := services.Auth.DeleteExpiredPasswordResets(); err != nil {
+				log.Println(err)
+			}
+			if err := services.Auth.DeleteUnverifiedUsers(); err != nil {
+				log.Println(err)
+			}
+			if err := services.Auth.DeleteExpiredLoginChallenges(); err != nil {
+				log.Println(err)
+			}
+			if err := services.Post.PublishScheduledPosts(); err != nil {
+				log.Println(err)
+			}
+			if err := services.Auth.DeleteStaleLoginAttempts(); err != nil {
+				log.Println(err)
+			}
 		}
 	}()
 
@@ -3,7 +3,17 @@ module github.com/ive663/forum
 go 1.19
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.16 // indirect
-	github.com/satori/uuid v1.2.0 // indirect
-	golang.org/x/crypto v0.5.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/microcosm-cc/bluemonday v1.0.21
+	github.com/satori/uuid v1.2.0
+	github.com/yuin/goldmark v1.5.4
+	golang.org/x/crypto v0.5.0
+	golang.org/x/image v0.5.0
+	golang.org/x/text v0.7.0
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/gorilla/css v1.0.0 // indirect
+	golang.org/x/net v0.5.0 // indirect
 )
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"crypto/rand"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Addr    string
+	BaseURL string
+	Mail    Mail
+	// Accounts that didn't confirm their email within UnverifiedTTL are deleted.
+	UnverifiedTTL time.Duration
+	// Admins are usernames promoted to administrators at startup.
+	Admins []string
+	// Require2FA forces moderators and administrators to enable two-factor authentication.
+	Require2FA bool
+	Login      Login
+	Password   Password
+	Sessions   Sessions
+	Cookie     Cookie
+	Uploads    Uploads
+	// PageSize is how many posts or comments a page of a listing shows.
+	PageSize int
+	// Secret keys the HMACs of CSRF tokens and signed cookies. When
+	// FORUM_SECRET is empty a random one is generated, so tokens and signed
+	// sessions stop matching after a restart.
+	Secret []byte
+}
+
+// Password is the policy applied when a password is chosen or changed.
+// MinClasses counts lowercase, uppercase, digits and symbols.
+type Password struct {
+	MinLength  int
+	MinClasses int
+}
+
+// Login limits failed sign-ins. After MaxFailures failures an account is locked
+// for Lockout, and every next failure doubles it. MaxFailuresIP does the same
+// per client address.
+type Login struct {
+	MaxFailures   int
+	MaxFailuresIP int
+	Lockout       time.Duration
+}
+
+// Sessions picks where sign-in sessions are kept: "sqlite", or "memory" where
+// they are lost on restart. Up to CacheSize of them are also kept in memory in
+// front of the store, 0 turns that off.
+// A session ends after TTL without requests, or RememberTTL when the user
+// ticked "remember me"; every request moves the end forward.
+type Sessions struct {
+	Store       string
+	CacheSize   int
+	TTL         time.Duration
+	RememberTTL time.Duration
+}
+
+// Cookie sets the attributes of the cookies the forum issues. With Sign the
+// session cookie carries an HMAC made with Secret.
+type Cookie struct {
+	Secure   bool
+	SameSite http.SameSite
+	Sign     bool
+}
+
+// Uploads limits the images attached to posts: MaxBytes each and MaxFiles per
+// post, 0 turns attachments off. Store picks where they are kept; "local"
+// writes them to files under Dir.
+type Uploads struct {
+	Store    string
+	Dir      string
+	MaxBytes int64
+	MaxFiles int
+}
+
+func (u Uploads) MaxKB() int64 {
+	return u.MaxBytes >> 10
+}
+
+type Mail struct {
+	Driver       string
+	From         string
+	File         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+}
+
+func New() *Config {
+	baseURL := getEnv("FORUM_BASE_URL", "http://localhost:8080")
+	cookie := getCookie(strings.HasPrefix(baseURL, "https://"))
+	return &Config{
+		Addr:    getEnv("FORUM_ADDR", ":8080"),
+		BaseURL: baseURL,
+		Mail: Mail{
+			Driver:       getEnv("FORUM_MAILER", "log"),
+			From:         getEnv("FORUM_MAIL_FROM", "forum@localhost"),
+			File:         getEnv("FORUM_MAIL_FILE", ""),
+			SMTPHost:     getEnv("FORUM_SMTP_HOST", "localhost"),
+			SMTPPort:     getEnv("FORUM_SMTP_PORT", "587"),
+			SMTPUser:     getEnv("FORUM_SMTP_USER", ""),
+			SMTPPassword: getEnv("FORUM_SMTP_PASSWORD", ""),
+		},
+		UnverifiedTTL: time.Duration(getEnvInt("FORUM_UNVERIFIED_DAYS", 7)) * 24 * time.Hour,
+		Admins:        getEnvList("FORUM_ADMINS"),
+		Require2FA:    getEnvBool("FORUM_REQUIRE_2FA", false),
+		Login: Login{
+			MaxFailures:   getEnvInt("FORUM_LOGIN_MAX_FAILURES", 5),
+			MaxFailuresIP: getEnvInt("FORUM_LOGIN_MAX_FAILURES_IP", 20),
+			Lockout:       time.Duration(getEnvInt("FORUM_LOGIN_LOCKOUT_SECONDS", 60)) * time.Second,
+		},
+		Password: Password{
+			MinLength:  getEnvInt("FORUM_PASSWORD_MIN_LENGTH", 8),
+			MinClasses: getEnvInt("FORUM_PASSWORD_MIN_CLASSES", 2),
+		},
+		Sessions: Sessions{
+			Store:       getEnv("FORUM_SESSION_STORE", "sqlite"),
+			CacheSize:   getEnvInt("FORUM_SESSION_CACHE_SIZE", 10000),
+			TTL:         time.Duration(getEnvInt("FORUM_SESSION_HOURS", 12)) * time.Hour,
+			RememberTTL: time.Duration(getEnvInt("FORUM_SESSION_REMEMBER_DAYS", 30)) * 24 * time.Hour,
+		},
+		Cookie: cookie,
+		Uploads: Uploads{
+			Store:    getEnv("FORUM_UPLOAD_STORE", "local"),
+			Dir:      getEnv("FORUM_UPLOAD_DIR", "uploads"),
+			MaxBytes: int64(getEnvInt("FORUM_UPLOAD_MAX_KB", 5<<10)) << 10,
+			MaxFiles: getEnvInt("FORUM_UPLOAD_MAX_FILES", 4),
+		},
+		PageSize: getEnvInt("FORUM_PAGE_SIZE", 20),
+		Secret:   getSecret("FORUM_SECRET", cookie.Sign),
+	}
+}
+
+func getEnv(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Error:config: %s=%q is not a number, using %d\n", key, v, def)
+		return def
+	}
+	return n
+}
+
+func getEnvBool(key string, def bool) bool {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Error:config: %s=%q is not a boolean, using %v\n", key, v, def)
+		return def
+	}
+	return b
+}
+
+// getSecret reads the secret from key, or makes up a random one that lasts
+// until the forum stops. signed tells whether session cookies depend on it.
+func getSecret(key string, signed bool) []byte {
+	if v := getEnv(key, ""); v != "" {
+		return []byte(v)
+	}
+	lost := "the forms open in browsers stop working"
+	if signed {
+		lost = "every user is signed out, \"remember me\" sessions included, and " + lost
+	}
+	log.Printf("WARNING:config: %s is not set, using a random secret. On every restart %s. Set %s to a long random string to keep them.\n", key, lost, key)
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Error:config: can't generate secret: ", err)
+	}
+	return b
+}
+
+func getCookie(https bool) Cookie {
+	c := Cookie{
+		Secure: getEnvBool("FORUM_COOKIE_SECURE", https),
+		Sign:   getEnvBool("FORUM_COOKIE_SIGN", true),
+	}
+	switch v := strings.ToLower(getEnv("FORUM_COOKIE_SAMESITE", "lax")); v {
+	case "lax":
+		c.SameSite = http.SameSiteLaxMode
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		c.SameSite = http.SameSiteNoneMode
+	default:
+		log.Printf("Error:config: FORUM_COOKIE_SAMESITE=%q is not lax, strict or none, using lax\n", v)
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		// browsers drop SameSite=None cookies that aren't Secure
+		log.Println("Error:config: FORUM_COOKIE_SAMESITE=none needs FORUM_COOKIE_SECURE, using lax")
+		c.SameSite = http.SameSiteLaxMode
+	}
+	return c
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(getEnv(key, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
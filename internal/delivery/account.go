@@ -0,0 +1,212 @@
+package delivery
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+type accountPage struct {
+	Login         string
+	Email         string
+	TwoFactor     bool
+	Error         string
+	Notice        string
+	Form          string // the form that Value and Errors belong to
+	Value         string
+	Errors        map[string]string
+	Authorization bool
+}
+
+var accountNotices = map[string]string{
+	"password": "Your password is changed. Other devices were signed out.",
+	"email":    "We sent a confirmation link to your new email. The change takes effect once you open it.",
+	"username": "Your username is changed.",
+}
+
+func (h *Handler) account(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/settings/account" {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	h.renderAccount(w, r, user, http.StatusOK, accountPage{Notice: accountNotices[r.URL.Query().Get("changed")]})
+}
+
+func (h *Handler) renderAccount(w http.ResponseWriter, r *http.Request, user *module.User, status int, page accountPage) {
+	t, err := parseTemplate(r, "templates/account.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	page.Login = user.Login
+	page.Email = user.Email
+	page.TwoFactor = user.TOTPEnabled
+	page.Authorization = true
+	w.WriteHeader(status)
+	if err := t.Execute(w, page); err != nil {
+		log.Print(err)
+	}
+}
+
+// changeAccount handles the forms that change the password, the email and
+// the username. Each of them asks for the current password.
+func (h *Handler) changeAccount(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	current := r.PostForm.Get("current")
+	var form, value string
+	var err error
+	switch r.URL.Path {
+	case "/settings/account/password":
+		form = "password"
+		session := ""
+		if s := currentSession(r); s != nil {
+			session = s.UUID
+		}
+		if err = h.services.Auth.ChangePassword(user.ID, session, current, r.PostForm.Get("password"), clientOf(r)); err == nil {
+			r, err = h.rotateSession(w, r)
+		}
+	case "/settings/account/email":
+		form, value = "email", r.PostForm.Get("email")
+		err = h.services.Auth.ChangeEmail(user.ID, current, value, clientOf(r))
+	case "/settings/account/username":
+		form, value = "username", r.PostForm.Get("username")
+		err = h.services.Auth.ChangeUsername(user.ID, current, value, clientOf(r))
+	default:
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	if err != nil {
+		var verr *service.ValidationError
+		if errors.As(err, &verr) {
+			h.renderAccount(w, r, user, http.StatusBadRequest, accountPage{Form: form, Value: value, Errors: verr.Fields})
+			return
+		}
+		if errors.Is(err, service.ErrTooManyAttempts) {
+			h.renderAccount(w, r, user, http.StatusTooManyRequests, accountPage{Error: err.Error()})
+			return
+		}
+		log.Println("ERROR:delivery:changeAccount: ", err)
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/settings/account?changed="+form, http.StatusSeeOther)
+}
+
+// deleteAccount asks for the password (and 2FA code) again, deletes the
+// account and signs the browser out.
+func (h *Handler) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.PostForm.Get("confirm") != "yes" {
+		h.renderAccount(w, r, user, http.StatusBadRequest, accountPage{Error: "Tick the box to confirm that you want to delete your account."})
+		return
+	}
+	removeContent := r.PostForm.Get("content") == "remove"
+	err := h.services.Auth.DeleteAccount(user.ID, r.PostForm.Get("password"), r.PostForm.Get("code"), removeContent, clientOf(r))
+	if err != nil {
+		if errors.Is(err, service.ErrReauthFailed) {
+			h.renderAccount(w, r, user, http.StatusForbidden, accountPage{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrTooManyAttempts) {
+			h.renderAccount(w, r, user, http.StatusTooManyRequests, accountPage{Error: err.Error()})
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.clearCookie(w, sessionCookie, "/")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// exportAccount sends the user's data as one JSON file, or with
+// ?format=zip as an archive with a file per kind of data.
+func (h *Handler) exportAccount(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	export, err := h.services.Auth.ExportAccount(user.ID)
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	name := "forum-" + user.Login + "-" + export.ExportedAt.Format("20060102")
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".json"}))
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			log.Println("ERROR:delivery:exportAccount:Encode: ", err)
+		}
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+		if err := writeExportZip(w, export); err != nil {
+			log.Println("ERROR:delivery:exportAccount:writeExportZip: ", err)
+		}
+	default:
+		h.Errors(w, http.StatusBadRequest, "format must be json or zip")
+	}
+}
+
+func writeExportZip(w http.ResponseWriter, export *module.Export) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"posts.json", export.Posts},
+		{"comments.json", export.Comments},
+		{"votes.json", export.Votes},
+		{"sessions.json", export.Sessions},
+		{"api_tokens.json", export.APITokens},
+	}
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
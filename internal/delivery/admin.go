@@ -0,0 +1,125 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+type locksPage struct {
+	Attempts      []module.LoginAttempt
+	Authorization bool
+}
+
+type usersPage struct {
+	Users         []module.User
+	Roles         []string
+	Current       *module.User
+	Authorization bool
+}
+
+func (h *Handler) loginLocks(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	switch r.Method {
+	case "GET":
+		attempts, err := h.services.Auth.GetLoginLocks(user)
+		if err != nil {
+			h.adminError(w, err)
+			return
+		}
+		t, err := parseTemplate(r, "templates/locks.html")
+		if err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+			return
+		}
+		if err := t.Execute(w, locksPage{Attempts: attempts, Authorization: true}); err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		}
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := h.services.Auth.ClearLoginLock(user, r.PostForm.Get("key")); err != nil {
+			h.adminError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/locks", http.StatusSeeOther)
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+func (h *Handler) users(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	switch r.Method {
+	case "GET":
+		users, err := h.services.Auth.GetUsers(user)
+		if err != nil {
+			h.adminError(w, err)
+			return
+		}
+		t, err := parseTemplate(r, "templates/users.html")
+		if err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+			return
+		}
+		page := usersPage{Users: users, Roles: module.Roles, Current: user, Authorization: true}
+		if err := t.Execute(w, page); err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		}
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		id, err := strconv.Atoi(r.PostForm.Get("id"))
+		if err != nil {
+			h.Errors(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if err := h.services.Auth.SetUserRole(user, id, r.PostForm.Get("role")); err != nil {
+			h.adminError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+func (h *Handler) adminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		h.Errors(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrUserNotFound):
+		h.Errors(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrInvalidRole):
+		h.Errors(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Println("ERROR:delivery:admin: ", err)
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// sessionCache reports the session cache counters as JSON.
+func (h *Handler) sessionCache(w http.ResponseWriter, r *http.Request) {
+	stats, ok := h.services.Auth.SessionCacheStats()
+	if !ok {
+		h.Errors(w, http.StatusNotFound, "session cache is turned off")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Println("ERROR:delivery:sessionCache: ", err)
+	}
+}
@@ -0,0 +1,136 @@
+package delivery
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+// tokenScopes lists the requests API tokens may make and the scope each one
+// needs. Everything else, account settings included, needs a browser session.
+var tokenScopes = map[string]map[string]string{
+	"/":           {http.MethodGet: module.ScopeRead},
+	"/post":       {http.MethodGet: module.ScopeRead, http.MethodPost: module.ScopePost},
+	"/createpost": {http.MethodPost: module.ScopePost},
+	"/vote":       {http.MethodPost: module.ScopeVote},
+	"/user/":      {http.MethodGet: module.ScopeRead},
+	"/search":     {http.MethodGet: module.ScopeRead},
+	"/c/":         {http.MethodGet: module.ScopeRead},
+}
+
+func tokenAllows(t *module.APIToken, r *http.Request) bool {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	scopes, ok := tokenScopes[r.URL.Path]
+	if !ok {
+		// "/user/" and the like cover every path below them
+		for prefix, s := range tokenScopes {
+			if len(prefix) > 1 && strings.HasSuffix(prefix, "/") && strings.HasPrefix(r.URL.Path, prefix) {
+				scopes, ok = s, true
+				break
+			}
+		}
+	}
+	scope, ok := scopes[method]
+	return ok && t.HasScope(scope)
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+type tokensPage struct {
+	Tokens        []module.APIToken
+	Scopes        []string
+	NewToken      string
+	Error         string
+	Authorization bool
+}
+
+func (h *Handler) apiTokens(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/settings/tokens" {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	user_id, ok := r.Context().Value(keyUserID).(int)
+	if !ok || user_id == 0 {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		h.renderTokens(w, r, user_id, http.StatusOK, tokensPage{})
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		switch r.PostForm.Get("action") {
+		case "create":
+			days, err := strconv.Atoi(r.PostForm.Get("days"))
+			if err != nil {
+				days = -1
+			}
+			token, err := h.services.Auth.CreateAPIToken(user_id, r.PostForm.Get("name"), r.PostForm["scope"], days)
+			if err != nil {
+				if errors.Is(err, service.ErrInvalidTokenName) || errors.Is(err, service.ErrInvalidScope) || errors.Is(err, service.ErrInvalidExpiry) {
+					h.renderTokens(w, r, user_id, http.StatusBadRequest, tokensPage{Error: err.Error()})
+					return
+				}
+				h.Errors(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			h.renderTokens(w, r, user_id, http.StatusOK, tokensPage{NewToken: token})
+		case "revoke":
+			id, err := strconv.Atoi(r.PostForm.Get("id"))
+			if err != nil {
+				h.Errors(w, http.StatusBadRequest, "invalid id")
+				return
+			}
+			if err := h.services.Auth.RevokeAPIToken(user_id, id); err != nil {
+				if errors.Is(err, service.ErrAPITokenNotFound) {
+					h.Errors(w, http.StatusNotFound, err.Error())
+					return
+				}
+				h.Errors(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			http.Redirect(w, r, "/settings/tokens", http.StatusSeeOther)
+		default:
+			h.Errors(w, http.StatusBadRequest, "unknown action")
+		}
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+func (h *Handler) renderTokens(w http.ResponseWriter, r *http.Request, userID, status int, page tokensPage) {
+	tokens, err := h.services.Auth.GetAPITokens(userID)
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page.Tokens, page.Scopes, page.Authorization = tokens, module.Scopes, true
+	t, err := parseTemplate(r, "templates/tokens.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, page); err != nil {
+		log.Print(err)
+	}
+}
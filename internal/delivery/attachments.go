@@ -0,0 +1,94 @@
+package delivery
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+const (
+	// multipart forms keep up to this much in memory, the rest goes to temporary files
+	maxFormMemory = 8 << 20
+	uploadField   = "images"
+)
+
+const errUploadTooLarge = "the images are too large, make them smaller or attach fewer"
+
+// parseForm reads the fields of a form. A multipart body may carry every
+// image a post can have and a little more for the text fields; anything
+// bigger ends with an *http.MaxBytesError.
+func (h *Handler) parseForm(w http.ResponseWriter, r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.ParseForm()
+	}
+	if r.MultipartForm != nil {
+		return nil
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.cfg.Uploads.MaxFiles)*h.cfg.Uploads.MaxBytes+1<<20)
+	return r.ParseMultipartForm(maxFormMemory)
+}
+
+// readUploads reads the images attached to a form. File inputs left empty
+// are sent as a part without a name, those are skipped.
+func readUploads(r *http.Request) ([]module.Upload, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	var uploads []module.Upload
+	for _, fh := range r.MultipartForm.File[uploadField] {
+		if fh.Filename == "" && fh.Size == 0 {
+			continue
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, module.Upload{Name: fh.Filename, Data: data})
+	}
+	return uploads, nil
+}
+
+// attachment serves an image attached to a post, or its thumbnail with
+// size=thumb. The headers keep browsers from treating it as anything but the
+// image type we checked it to be.
+func (h *Handler) attachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	thumb := r.URL.Query().Get("size") == "thumb"
+	a, blob, err := h.services.OpenAttachment(currentUser(r), id, thumb)
+	if err != nil {
+		if errors.Is(err, service.ErrAttachmentNotFound) {
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer blob.Close()
+	contentType, name := a.ContentType, "attachment-"+strconv.Itoa(a.ID)+path.Ext(a.Key)
+	if thumb {
+		contentType, name = a.ThumbType, "attachment-"+strconv.Itoa(a.ID)+"-thumb"+path.Ext(a.ThumbKey)
+	}
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	header.Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, name, a.Date, blob)
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"text/template"
 	"time"
 
 	"github.com/ive663/forum/internal/module"
@@ -21,16 +20,7 @@ func (h *Handler) signup(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case "GET":
-		t, err := template.ParseFiles("templates/signup.html")
-		if err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
-			return
-		}
-		if err = t.Execute(w, nil); err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error executing")
-		}
+		h.renderSignup(w, r, http.StatusOK, signupForm{})
 	case "POST":
 		if err := r.ParseForm(); err != nil {
 			h.Errors(w, http.StatusInternalServerError, err.Error())
@@ -52,6 +42,7 @@ func (h *Handler) signup(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			log.Println("delivery:error: invalid password")
 			h.Errors(w, http.StatusBadRequest, "Please use stronger password")
+			return
 		}
 		user := &module.User{
 			Login:    username[0],
@@ -60,6 +51,16 @@ func (h *Handler) signup(w http.ResponseWriter, r *http.Request) {
 		}
 		newUser, err := h.services.Auth.CreateNewUser(user)
 		if err != nil {
+			var verr *service.ValidationError
+			if errors.As(err, &verr) {
+				log.Println(":delivery:error: invalid email or password or username")
+				h.renderSignup(w, r, http.StatusBadRequest, signupForm{
+					Username: username[0],
+					Email:    email[0],
+					Errors:   verr.Fields,
+				})
+				return
+			}
 			if errors.Is(err, service.ErrInvalidEmail) || errors.Is(err, service.ErrInvalidPassword) || errors.Is(err, service.ErrInvalidUserName) {
 				w.WriteHeader(http.StatusBadRequest)
 				h.Errors(w, http.StatusBadRequest, err.Error())
@@ -70,7 +71,7 @@ func (h *Handler) signup(w http.ResponseWriter, r *http.Request) {
 			log.Println("delivery:error: can't create user")
 			return
 		}
-		tkn, err := h.services.Auth.GenerateSessionToken(newUser.Login, password[0])
+		tkn, err := h.services.Auth.GenerateSessionToken(newUser.Login, password[0], false, clientOf(r))
 		password[0] = ""
 		user.Password = ""
 		if err != nil {
@@ -83,13 +84,7 @@ func (h *Handler) signup(w http.ResponseWriter, r *http.Request) {
 			log.Println("delivery:error: token is empty")
 			return
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:    "session",
-			Value:   tkn,
-			Path:    "/",
-			Secure:  true,
-			Expires: time.Now().Add(12 * time.Hour),
-		})
+		h.setSessionCookie(w, tkn, false)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	default:
@@ -97,6 +92,25 @@ func (h *Handler) signup(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type signupForm struct {
+	Username string
+	Email    string
+	Errors   map[string]string
+}
+
+func (h *Handler) renderSignup(w http.ResponseWriter, r *http.Request, status int, form signupForm) {
+	t, err := parseTemplate(r, "templates/signup.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err = t.Execute(w, form); err != nil {
+		log.Print(err)
+	}
+}
+
 func (h *Handler) signin(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/signin" {
 		h.Errors(w, http.StatusNotFound, "")
@@ -105,7 +119,7 @@ func (h *Handler) signin(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "GET":
-		t, err := template.ParseFiles("templates/signin.html")
+		t, err := parseTemplate(r, "templates/signin.html")
 		if err != nil {
 			h.Errors(w, http.StatusInternalServerError, err.Error())
 			return
@@ -142,8 +156,22 @@ func (h *Handler) signin(w http.ResponseWriter, r *http.Request) {
 			log.Println("error: invalid password")
 			return
 		}
-		token, err := h.services.Auth.GenerateSessionToken(username[0], password[0])
+		remember := r.PostForm.Get("remember") != ""
+		token, err := h.services.Auth.GenerateSessionToken(username[0], password[0], remember, clientOf(r))
+		var challenge *service.ChallengeError
+		if errors.As(err, &challenge) {
+			c := h.newCookie(challengeCookie, challenge.Token, "/signin")
+			c.Expires = time.Now().Add(5 * time.Minute)
+			http.SetCookie(w, c)
+			http.Redirect(w, r, "/signin/2fa", http.StatusSeeOther)
+			return
+		}
 		if err != nil {
+			if errors.Is(err, service.ErrTooManyAttempts) {
+				log.Println("error: sign-in locked for ", username[0])
+				h.Errors(w, http.StatusTooManyRequests, err.Error())
+				return
+			}
 			if errors.Is(err, service.ErrUserNotFound) {
 				log.Println("error: user not found. can't generate token")
 				h.Errors(w, http.StatusUnauthorized, err.Error())
@@ -157,13 +185,7 @@ func (h *Handler) signin(w http.ResponseWriter, r *http.Request) {
 			log.Println("log:session: token is empty, new session not created")
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:    "session",
-			Value:   token,
-			Path:    "/",
-			Secure:  true,
-			Expires: time.Now().Add(12 * time.Hour),
-		})
+		h.setSessionCookie(w, token, remember)
 		password[0] = ""
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -181,26 +203,171 @@ func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
 		h.Errors(w, http.StatusMethodNotAllowed, "")
 		return
 	}
-	c, err := r.Cookie("session")
+	token := h.sessionToken(r)
+	if token == "" {
+		log.Println("error: nil cookies")
+		h.Errors(w, http.StatusUnauthorized, "Error in cookie")
+		return
+	}
+	if err := h.services.Auth.DeleteSessionToken(token); err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		log.Println("error: can't delete session token")
+		return
+	}
+	h.clearCookie(w, sessionCookie, "/")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/forgot" {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	t, err := parseTemplate(r, "templates/forgot.html")
 	if err != nil {
-		if err == http.ErrNoCookie {
-			log.Println("error: nil cookies")
-			h.Errors(w, http.StatusUnauthorized, "Error in cookie")
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if err = t.Execute(w, nil); err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		}
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		log.Println("error: can't get cookie")
-		h.Errors(w, http.StatusBadRequest, "Error in cookie")
+		email := r.PostForm.Get("email")
+		if email == "" {
+			h.Errors(w, http.StatusBadRequest, "Please... use jon@smith.com format")
+			return
+		}
+		if err := h.services.Auth.RequestPasswordReset(email); err != nil {
+			log.Println("ERROR:delivery:Auth:forgotPassword:RequestPasswordReset: ", err)
+			h.Errors(w, http.StatusInternalServerError, "Can't send reset letter, try again later")
+			return
+		}
+		if err = t.Execute(w, struct{ Sent bool }{true}); err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		}
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+type resetForm struct {
+	Token  string
+	Errors map[string]string
+}
+
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/reset" {
+		h.Errors(w, http.StatusNotFound, "")
 		return
 	}
-	if err := h.services.Auth.DeleteSessionToken(c.Value); err != nil {
+	switch r.Method {
+	case "GET":
+		token := r.URL.Query().Get("token")
+		if err := h.services.Auth.CheckPasswordResetToken(token); err != nil {
+			if errors.Is(err, service.ErrInvalidToken) {
+				h.Errors(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		t, err := parseTemplate(r, "templates/reset.html")
+		if err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+			return
+		}
+		if err = t.Execute(w, resetForm{Token: token}); err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		}
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		password := r.PostForm.Get("password")
+		if password != r.PostForm.Get("confirm") {
+			h.Errors(w, http.StatusBadRequest, "Passwords don't match")
+			return
+		}
+		if err := h.services.Auth.ResetPassword(r.PostForm.Get("token"), password); err != nil {
+			var verr *service.ValidationError
+			if errors.As(err, &verr) {
+				t, err := parseTemplate(r, "templates/reset.html")
+				if err != nil {
+					log.Print(err)
+					h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+					return
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				if err = t.Execute(w, resetForm{Token: r.PostForm.Get("token"), Errors: verr.Fields}); err != nil {
+					log.Print(err)
+				}
+				return
+			}
+			if errors.Is(err, service.ErrInvalidToken) {
+				h.Errors(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			log.Println("ERROR:delivery:Auth:resetPassword:ResetPassword: ", err)
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := h.services.Auth.VerifyEmail(r.URL.Query().Get("token")); err != nil {
+		if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrEmailTaken) {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		h.Errors(w, http.StatusInternalServerError, err.Error())
-		log.Println("error: can't delete session token")
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session",
-		Value:   "",
-		Expires: time.Now(),
-	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) resendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	user_id, ok := r.Context().Value(keyUserID).(int)
+	if !ok || user_id == 0 {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	user, err := h.services.GetUserByUserID(user_id)
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.services.Auth.SendVerification(user); err != nil {
+		if errors.Is(err, service.ErrAlreadyVerified) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		log.Println("ERROR:delivery:Auth:resendVerification:SendVerification: ", err)
+		h.Errors(w, http.StatusInternalServerError, "Can't send the letter, try again later")
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -0,0 +1,215 @@
+package delivery
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+// categoryPicker lets a form pick categories from the catalogue. Picked
+// holds the slugs that are checked.
+type categoryPicker struct {
+	Catalogue []module.Category
+	Picked    map[string]bool
+}
+
+func (h *Handler) newCategoryPicker(picked []string) (categoryPicker, error) {
+	catalogue, err := h.services.GetCatalogue()
+	if err != nil {
+		return categoryPicker{}, err
+	}
+	p := categoryPicker{Catalogue: catalogue, Picked: make(map[string]bool)}
+	for _, slug := range picked {
+		p.Picked[module.Slugify(slug)] = true
+	}
+	return p, nil
+}
+
+// categoryValues reads the categories a form picked. API clients may send
+// them in one value, separated by spaces, as the tags of posts once were.
+func categoryValues(form url.Values) []string {
+	var categories []string
+	for _, v := range form["category"] {
+		categories = append(categories, strings.Fields(v)...)
+	}
+	return categories
+}
+
+type categoriesPage struct {
+	Categories    []module.Category
+	Authorization bool
+}
+
+type categoryPage struct {
+	Category      *module.Category
+	Posts         module.PostList
+	Authorization bool
+	Next          string
+	Prev          string
+	Sorts         []sortLink
+	Windows       []sortLink
+}
+
+// categories lists the catalogue at /c/ and shows a category with its posts
+// at /c/{slug}.
+func (h *Handler) categories(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimPrefix(r.URL.Path, "/c/")
+	if slug == "" {
+		h.categoryList(w, r)
+		return
+	}
+	if strings.Contains(slug, "/") {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	c, err := h.services.GetCategory(slug)
+	if err != nil {
+		if errors.Is(err, service.ErrCategoryNotFound) {
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	query := r.URL.Query()
+	page, err := h.pageQuery(query)
+	if err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sort := postSort(query)
+	posts, pagination, err := h.services.GetPosts(module.PostFilter{Categories: []string{c.Slug}}, sort, page)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidQueryRequest) {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := categoryPage{Category: c, Posts: posts.PrepToView(), Authorization: currentUser(r) != nil}
+	data.Next, data.Prev = pageLinks(r, pagination)
+	data.Sorts, data.Windows = sortLinks(r, sort)
+	t, err := parseTemplate(r, "templates/category.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Println("ERROR:delivery:categories: ", err)
+	}
+}
+
+func (h *Handler) categoryList(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.services.GetCategories()
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t, err := parseTemplate(r, "templates/categories.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	if err := t.Execute(w, categoriesPage{Categories: categories, Authorization: currentUser(r) != nil}); err != nil {
+		log.Println("ERROR:delivery:categoryList: ", err)
+	}
+}
+
+type categoryAdminPage struct {
+	Categories []module.Category
+	// New is the category being added, kept when it was refused.
+	New           module.Category
+	Error         string
+	Authorization bool
+}
+
+// adminCategories lets administrators add, change and delete categories.
+func (h *Handler) adminCategories(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	page := categoryAdminPage{New: module.Category{Color: module.DefaultCategoryColor}, Authorization: true}
+	status := http.StatusOK
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		c := &module.Category{
+			Name:        r.PostForm.Get("name"),
+			Slug:        r.PostForm.Get("slug"),
+			Description: r.PostForm.Get("description"),
+			Color:       r.PostForm.Get("color"),
+		}
+		var err error
+		if v := r.PostForm.Get("position"); v != "" {
+			if c.Position, err = strconv.Atoi(v); err != nil {
+				h.Errors(w, http.StatusBadRequest, "invalid position")
+				return
+			}
+		}
+		action := r.PostForm.Get("action")
+		if action != "create" {
+			if c.ID, err = strconv.Atoi(r.PostForm.Get("id")); err != nil {
+				h.Errors(w, http.StatusBadRequest, "invalid id")
+				return
+			}
+		}
+		switch action {
+		case "create":
+			err = h.services.CreateCategory(user, c)
+		case "update":
+			err = h.services.UpdateCategory(user, c)
+		case "delete":
+			err = h.services.DeleteCategory(user, c.ID)
+		default:
+			h.Errors(w, http.StatusBadRequest, "invalid action")
+			return
+		}
+		switch {
+		case err == nil:
+			http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+			return
+		case errors.Is(err, service.ErrInvalidCategory) || errors.Is(err, service.ErrEmptyValue) || errors.Is(err, service.ErrCategoryExists):
+			page.Error = err.Error()
+			if action == "create" {
+				page.New = *c
+			}
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrCategoryNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		default:
+			h.adminError(w, err)
+			return
+		}
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	categories, err := h.services.GetCategories()
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page.Categories = categories
+	t, err := parseTemplate(r, "templates/category_admin.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, page); err != nil {
+		log.Println("ERROR:delivery:adminCategories: ", err)
+	}
+}
@@ -0,0 +1,93 @@
+package delivery
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	sessionCookie   = "session"
+	challengeCookie = "signin_challenge"
+)
+
+// newCookie fills in the attributes every cookie of the forum shares.
+func (h *Handler) newCookie(name, value, path string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   h.cfg.Cookie.Secure,
+		SameSite: h.cfg.Cookie.SameSite,
+	}
+}
+
+func (h *Handler) clearCookie(w http.ResponseWriter, name, path string) {
+	c := h.newCookie(name, "", path)
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+// setSessionCookie hands the session token to the browser. Without remember
+// the cookie lives until the browser is closed.
+func (h *Handler) setSessionCookie(w http.ResponseWriter, token string, remember bool) {
+	c := h.newCookie(sessionCookie, h.signCookie(sessionCookie, token), "/")
+	if remember {
+		c.MaxAge = int(h.cfg.Sessions.RememberTTL.Seconds())
+	}
+	http.SetCookie(w, c)
+}
+
+// sessionToken returns the session token of the request, or "" when there is
+// none or its signature doesn't match.
+func (h *Handler) sessionToken(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	if !h.cfg.Cookie.Sign {
+		return c.Value
+	}
+	i := strings.LastIndexByte(c.Value, '.')
+	if i < 0 {
+		return ""
+	}
+	token := c.Value[:i]
+	if !hmac.Equal([]byte(c.Value), []byte(h.signCookie(sessionCookie, token))) {
+		return ""
+	}
+	return token
+}
+
+// signCookie appends an HMAC of the cookie name and value, so values can't
+// be made up or moved between cookies. It returns value as is when signing
+// is off.
+func (h *Handler) signCookie(name, value string) string {
+	if !h.cfg.Cookie.Sign {
+		return value
+	}
+	mac := hmac.New(sha256.New, h.cfg.Secret)
+	mac.Write([]byte(name + "=" + value))
+	return value + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// rotateSession gives the browser a new session token after the user's
+// privileges changed. The returned request carries the CSRF token that goes
+// with the new session, for pages rendered in the same response.
+func (h *Handler) rotateSession(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	session := currentSession(r)
+	if session == nil {
+		return r, nil
+	}
+	token, err := h.services.Auth.RotateSession(session.UUID)
+	if err != nil {
+		return r, err
+	}
+	h.setSessionCookie(w, token, session.Remember)
+	ctx := context.WithValue(r.Context(), keyCSRFToken, h.csrfToken("session:"+token))
+	return r.WithContext(ctx), nil
+}
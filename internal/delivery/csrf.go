@@ -0,0 +1,139 @@
+package delivery
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/ive663/forum/internal/markdown"
+)
+
+const (
+	csrfCookie = "csrf"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+const errCSRF = "invalid or missing CSRF token, reload the page and try again"
+
+func (h *Handler) csrfToken(seed string) string {
+	mac := hmac.New(sha256.New, h.cfg.Secret)
+	mac.Write([]byte(seed))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// csrf rejects state-changing requests that don't come from our own pages.
+// The token is an HMAC of the session cookie, or of an anonymous csrf cookie
+// for visitors who are not signed in yet.
+func (h *Handler) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers never attach an Authorization header on their own, so
+		// requests with an API token can't be forged by another site.
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var seed string
+		if token := h.sessionToken(r); token != "" {
+			seed = "session:" + token
+		} else if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+			seed = "anon:" + c.Value
+		} else if isSafeMethod(r.Method) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				log.Println("ERROR:delivery:csrf:rand.Read: ", err)
+				h.Errors(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			value := hex.EncodeToString(b)
+			http.SetCookie(w, h.newCookie(csrfCookie, value, "/"))
+			seed = "anon:" + value
+		}
+		token := ""
+		if seed != "" {
+			token = h.csrfToken(seed)
+		}
+		if !isSafeMethod(r.Method) {
+			if !h.sameOrigin(r) {
+				h.Errors(w, http.StatusForbidden, "cross-origin request refused")
+				return
+			}
+			got := r.Header.Get(csrfHeader)
+			if got == "" {
+				// forms with files are limited here, before their body is read
+				var tooLarge *http.MaxBytesError
+				if err := h.parseForm(w, r); errors.As(err, &tooLarge) {
+					h.Errors(w, http.StatusRequestEntityTooLarge, errUploadTooLarge)
+					return
+				}
+				got = r.PostForm.Get(csrfField)
+			}
+			if token == "" || !hmac.Equal([]byte(got), []byte(token)) {
+				h.Errors(w, http.StatusForbidden, errCSRF)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), keyCSRFToken, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// sameOrigin checks the headers browsers attach to every request. Clients that
+// send neither are let through; the token still has to match.
+func (h *Handler) sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if u.Host == r.Host {
+		return true
+	}
+	base, err := url.Parse(h.cfg.BaseURL)
+	return err == nil && u.Scheme == base.Scheme && u.Host == base.Host
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(keyCSRFToken).(string)
+	return token
+}
+
+// parseTemplate parses a page with the functions every form needs:
+// {{ csrfField }} renders the hidden token input and {{ csrfToken }} the bare token.
+// {{ markdown .Message }} renders what users wrote and {{ highlight .Snippet }}
+// what a search found.
+func parseTemplate(r *http.Request, file string) (*template.Template, error) {
+	token := csrfToken(r)
+	return template.New(filepath.Base(file)).Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+		"csrfToken": func() string { return token },
+		"markdown":  markdown.Render,
+		"highlight": highlight,
+	}).ParseFiles(file)
+}
@@ -0,0 +1,169 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+// publishAtLayouts are the values a datetime-local input sends.
+var publishAtLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05"}
+
+// parsePublishAt reads the time a post is scheduled for. The browser sends
+// it without a zone, so the form adds the user's offset from UTC in minutes,
+// as JavaScript's getTimezoneOffset gives it; without one the server's zone
+// is used. An empty value means the post is published right away.
+func parsePublishAt(value, tz string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	loc := time.Local
+	if tz != "" {
+		offset, err := strconv.Atoi(tz)
+		if err != nil || offset < -14*60 || offset > 14*60 {
+			return time.Time{}, service.ErrInvalidPublishTime
+		}
+		loc = time.FixedZone("", -offset*60)
+	}
+	for _, layout := range publishAtLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, service.ErrInvalidPublishTime
+}
+
+type draftsPage struct {
+	Drafts        []module.Draft
+	Scheduled     module.PostList
+	Error         string
+	Authorization bool
+}
+
+// drafts lists the drafts and the scheduled posts of the user.
+func (h *Handler) drafts(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	h.renderDrafts(w, r, user.ID, http.StatusOK, draftsPage{})
+}
+
+func (h *Handler) renderDrafts(w http.ResponseWriter, r *http.Request, userID, status int, page draftsPage) {
+	drafts, err := h.services.GetDrafts(userID)
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	scheduled, err := h.services.GetScheduledPosts(userID)
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page.Drafts, page.Scheduled, page.Authorization = drafts, scheduled, true
+	t, err := parseTemplate(r, "templates/drafts.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, page); err != nil {
+		log.Println("ERROR:delivery:drafts: ", err)
+	}
+}
+
+type savedDraft struct {
+	ID      int       `json:"id"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// saveDraft stores the post form as a draft. The editor autosaves with it and
+// asks for JSON; the "Save draft" button gets the drafts page.
+func (h *Handler) saveDraft(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		h.Errors(w, http.StatusUnauthorized, "")
+		return
+	}
+	if err := h.parseForm(w, r); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.Errors(w, http.StatusRequestEntityTooLarge, errUploadTooLarge)
+			return
+		}
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.MultipartForm != nil {
+		// images are not kept with drafts
+		defer r.MultipartForm.RemoveAll()
+	}
+	draft := &module.Draft{
+		UserID:  user.ID,
+		Title:   r.PostForm.Get("title"),
+		Message: r.PostForm.Get("message"),
+		Tags:    strings.Join(categoryValues(r.PostForm), " "),
+	}
+	if v := r.PostForm.Get("draft"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			h.Errors(w, http.StatusBadRequest, "invalid draft id")
+			return
+		}
+		draft.ID = id
+	}
+	if err := h.services.SaveDraft(draft); err != nil {
+		switch {
+		case errors.Is(err, service.ErrDraftNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrTooManyDrafts) || errors.Is(err, service.ErrInvalidTypingPost):
+			h.Errors(w, http.StatusBadRequest, err.Error())
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(savedDraft{ID: draft.ID, SavedAt: draft.UpdatedAt}); err != nil {
+			log.Println("ERROR:delivery:saveDraft:Encode: ", err)
+		}
+		return
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+func (h *Handler) deleteDraft(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := h.services.DeleteDraft(user.ID, id); err != nil {
+		if errors.Is(err, service.ErrDraftNotFound) {
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
@@ -4,34 +4,66 @@ import (
 	"net/http"
 	"text/template"
 
+	"github.com/ive663/forum/internal/config"
+	"github.com/ive663/forum/internal/module"
 	"github.com/ive663/forum/internal/service"
 )
 
 type Handler struct {
 	templates *template.Template
 	services  *service.Service
+	cfg       *config.Config
 }
 
-func NewHandler(services *service.Service) *Handler {
+func NewHandler(services *service.Service, cfg *config.Config) *Handler {
 	return &Handler{
 		services: services,
+		cfg:      cfg,
 	}
 }
 
-func (h *Handler) Handlers() *http.ServeMux {
+func (h *Handler) Handlers() http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("./static"))))
 	mux.HandleFunc("/", h.authenticateUser(h.index))
 	mux.HandleFunc("/signin", h.signin)
+	mux.HandleFunc("/signin/2fa", h.signinSecondFactor)
 	mux.HandleFunc("/signup", h.signup)
-	mux.HandleFunc("/createpost", h.authenticateUser(h.createpost))
+	mux.HandleFunc("/createpost", h.authenticateUser(h.requireVerified(h.createpost)))
+	mux.HandleFunc("/drafts", h.allowMethods(h.authenticateUser(h.requireVerified(h.drafts)), http.MethodGet))
+	mux.HandleFunc("/drafts/save", h.allowMethods(h.authenticateUser(h.requireVerified(h.saveDraft)), http.MethodPost))
+	mux.HandleFunc("/drafts/delete", h.allowMethods(h.authenticateUser(h.deleteDraft), http.MethodPost))
 	mux.HandleFunc("/logout", h.logout)
+	mux.HandleFunc("/forgot", h.forgotPassword)
+	mux.HandleFunc("/reset", h.resetPassword)
+	mux.HandleFunc("/verify", h.verifyEmail)
+	mux.HandleFunc("/verify/resend", h.authenticateUser(h.resendVerification))
+	mux.HandleFunc("/sessions", h.authenticateUser(h.sessions))
+	mux.HandleFunc("/sessions/revoke", h.authenticateUser(h.revokeSession))
+	mux.HandleFunc("/settings/2fa", h.authenticateUser(h.twoFactorSettings))
+	mux.HandleFunc("/settings/tokens", h.authenticateUser(h.requireVerified(h.apiTokens)))
+	mux.HandleFunc("/settings/profile", h.allowMethods(h.authenticateUser(h.editProfile), http.MethodPost))
+	mux.HandleFunc("/user/", h.authenticateUser(h.profile))
+	mux.HandleFunc("/settings/account", h.authenticateUser(h.account))
+	mux.HandleFunc("/settings/account/export", h.allowMethods(h.authenticateUser(h.exportAccount), http.MethodGet))
+	mux.HandleFunc("/settings/account/delete", h.allowMethods(h.authenticateUser(h.deleteAccount), http.MethodPost))
+	for _, path := range []string{"/settings/account/password", "/settings/account/email", "/settings/account/username"} {
+		mux.HandleFunc(path, h.allowMethods(h.authenticateUser(h.changeAccount), http.MethodPost))
+	}
+	admin := CreateChain(h.requireRole(module.RoleAdmin))
+	mux.HandleFunc("/admin/locks", h.authenticateUser(admin.Then(http.HandlerFunc(h.loginLocks)).ServeHTTP))
+	mux.HandleFunc("/admin/users", h.authenticateUser(admin.Then(http.HandlerFunc(h.users)).ServeHTTP))
+	mux.HandleFunc("/admin/categories", h.authenticateUser(admin.Then(http.HandlerFunc(h.adminCategories)).ServeHTTP))
+	mux.HandleFunc("/admin/sessions/cache", h.allowMethods(h.authenticateUser(admin.Then(http.HandlerFunc(h.sessionCache)).ServeHTTP), http.MethodGet))
+	mux.HandleFunc("/c/", h.allowMethods(h.authenticateUser(h.categories), http.MethodGet))
+	mux.HandleFunc("/search", h.allowMethods(h.authenticateUser(h.search), http.MethodGet))
 	mux.HandleFunc("/post", h.authenticateUser(h.post))
-	mux.HandleFunc("/likepost", h.authenticateUser(h.likePost))
-	mux.HandleFunc("/likepostindex", h.authenticateUser(h.likePostIndex))
-	mux.HandleFunc("/likecomment", h.authenticateUser(h.likeComment))
-	mux.HandleFunc("/dislikecomment", h.authenticateUser(h.dislikeComment))
-	mux.HandleFunc("/dislikepost", h.authenticateUser(h.dislikePost))
-	mux.HandleFunc("/dislikepostindex", h.authenticateUser(h.dislikePostIndex))
-	return mux
+	mux.HandleFunc("/post/edit", h.allowMethods(h.authenticateUser(h.requireVerified(h.editPost)), http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/post/delete", h.allowMethods(h.authenticateUser(h.deletePost), http.MethodPost))
+	mux.HandleFunc("/preview", h.allowMethods(h.authenticateUser(h.previewMarkdown), http.MethodPost))
+	mux.HandleFunc("/attachment", h.allowMethods(h.authenticateUser(h.attachment), http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/post/history", h.allowMethods(h.authenticateUser(h.postHistory), http.MethodGet))
+	mux.HandleFunc("/comment/delete", h.allowMethods(h.authenticateUser(h.deleteComment), http.MethodPost))
+	mux.HandleFunc("/vote", h.allowMethods(h.authenticateUser(h.requireVerified(h.vote)), http.MethodPost))
+	return CreateChain(h.csrf).Then(mux)
 }
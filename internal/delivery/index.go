@@ -2,9 +2,10 @@ package delivery
 
 import (
 	"errors"
-	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/ive663/forum/internal/module"
 	"github.com/ive663/forum/internal/service"
@@ -12,6 +13,114 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+type indexPage struct {
+	module.User
+	Filter module.PostFilter
+	categoryPicker
+	Sort    module.PostSort
+	Next    string
+	Prev    string
+	Sorts   []sortLink
+	Windows []sortLink
+}
+
+type sortLink struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// Listings are sorted with ?sort=, and top posts picked from a ?window=.
+const (
+	sortParam   = "sort"
+	windowParam = "window"
+)
+
+var sortLabels = map[string]string{
+	module.SortNew:           "New",
+	module.SortOld:           "Old",
+	module.SortTop:           "Top",
+	module.SortControversial: "Controversial",
+	module.SortHot:           "Hot",
+}
+
+var windowLabels = []struct{ window, label string }{
+	{"day", "Today"}, {"week", "This week"}, {"month", "This month"}, {"all", "All time"},
+}
+
+// postSort reads the order of a listing from query and removes it, so the
+// rest can be read as filters.
+func postSort(query url.Values) module.PostSort {
+	sort := module.PostSort{By: query.Get(sortParam), Window: query.Get(windowParam)}
+	query.Del(sortParam)
+	query.Del(windowParam)
+	if sort.By == "" {
+		sort.By = module.SortNew
+	}
+	if sort.By == module.SortTop && sort.Window == "" {
+		sort.Window = "all"
+	}
+	return sort
+}
+
+// postFilter reads the filters of a listing from query. Parameters it
+// doesn't know are left alone. A filter is on with 1, on or true, anything
+// else leaves it off; ?mypost=mypost and ?mylikedposts=mylikedposts are the
+// names the first filters had.
+func postFilter(query url.Values) module.PostFilter {
+	set := func(keys ...string) bool {
+		for _, key := range keys {
+			switch strings.ToLower(query.Get(key)) {
+			case "1", "on", "true", key:
+				return true
+			}
+		}
+		return false
+	}
+	filter := module.PostFilter{
+		AllCategories: query.Get("match") == "all",
+		Mine:          set("mine", "mypost"),
+		Liked:         set("liked", "mylikedposts"),
+		Disliked:      set("disliked"),
+		Commented:     set("commented"),
+		NoComments:    set("nocomments"),
+		From:          query.Get("from"),
+		To:            query.Get("to"),
+	}
+	filter.Categories = categoryValues(query)
+	return filter
+}
+
+// sortLinks links to the other orders of the listing r shows, with the same
+// filters, from the first page.
+func sortLinks(r *http.Request, sort module.PostSort) (sorts, windows []sortLink) {
+	link := func(by, window string) string {
+		query := r.URL.Query()
+		for _, key := range []string{afterParam, beforeParam, sortParam, windowParam} {
+			query.Del(key)
+		}
+		if by != module.SortNew {
+			query.Set(sortParam, by)
+		}
+		if window != "" {
+			query.Set(windowParam, window)
+		}
+		if len(query) == 0 {
+			return r.URL.Path
+		}
+		return r.URL.Path + "?" + query.Encode()
+	}
+	for _, by := range module.Sorts {
+		sorts = append(sorts, sortLink{Label: sortLabels[by], URL: link(by, ""), Active: by == sort.By})
+	}
+	if sort.By == module.SortTop {
+		for _, w := range windowLabels {
+			windows = append(windows, sortLink{Label: w.label, URL: link(module.SortTop, w.window), Active: w.window == sort.Window})
+		}
+	}
+	return sorts, windows
+}
+
 func (h *Handler) index(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		h.Errors(w, http.StatusNotFound, "")
@@ -27,37 +136,59 @@ func (h *Handler) index(w http.ResponseWriter, r *http.Request) {
 		if user_id == 0 {
 			user_authorization = false
 		}
-		t, err := template.ParseFiles("./templates/index.html")
+		t, err := parseTemplate(r, "templates/index.html")
 		if err != nil {
 			log.Print("err:delivery:index: ParseFiles", err)
 			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
 			return
 		}
-		var posts module.PostList
-		if len(r.URL.Query()) == 0 {
-			posts, err = h.services.GetNewPosts()
-			if err != nil {
-				log.Print("err:delivery:index: GetNewPosts")
-				h.Errors(w, http.StatusInternalServerError, err.Error())
-			}
-		} else {
-			posts, err = h.services.GetAllPostBy(user_id, r.URL.Query())
-			if err != nil {
-				log.Print("err:delivery:index: GetAllPostBy")
-				if errors.Is(err, service.ErrInvalidQueryRequest) {
-					h.Errors(w, http.StatusNotFound, "Invalid query request")
-					return
-				}
-				h.Errors(w, http.StatusInternalServerError, err.Error())
+		query := r.URL.Query()
+		page, err := h.pageQuery(query)
+		if err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sort := postSort(query)
+		filter := postFilter(query)
+		if filter.Personal() && !user_authorization {
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		filter.UserID = user_id
+		posts, pagination, err := h.services.GetPosts(filter, sort, page)
+		if err != nil {
+			log.Print("err:delivery:index: GetPosts")
+			if errors.Is(err, service.ErrInvalidQueryRequest) {
+				h.Errors(w, http.StatusBadRequest, err.Error())
 				return
 			}
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
 		}
 		u := module.User{
 			Posts:         posts.PrepToView(),
 			Authorization: user_authorization,
 		}
+		if user_authorization {
+			user, err := h.services.GetUserByUserID(user_id)
+			if err != nil {
+				log.Print("err:delivery:index: GetUserByUserID")
+				h.Errors(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			u.Verified = user.Verified
+			u.Role = user.Role
+			u.Login = user.Login
+		}
 
-		if err = t.Execute(w, u); err != nil {
+		data := indexPage{User: u, Filter: filter, Sort: sort}
+		if data.categoryPicker, err = h.newCategoryPicker(filter.Categories); err != nil {
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		data.Next, data.Prev = pageLinks(r, pagination)
+		data.Sorts, data.Windows = sortLinks(r, sort)
+		if err = t.Execute(w, data); err != nil {
 			log.Print(err)
 			log.Print("err:delivery:index: Execute")
 			h.Errors(w, http.StatusInternalServerError, "Error executing file")
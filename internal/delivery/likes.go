@@ -1,180 +1,103 @@
 package delivery
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
 )
 
-func (h *Handler) likePost(w http.ResponseWriter, r *http.Request) {
-	postid, err := strconv.Atoi(r.URL.Query().Get("postid"))
-	if err != nil {
-		h.Errors(w, http.StatusNotFound, "")
-		return
-	}
+// vote toggles a like or dislike on a post or comment. The form sends
+// kind (post or comment), id and vote (like or dislike). Scripts asking for
+// JSON get the new counters back, everyone else is redirected to next or to
+// the post.
+func (h *Handler) vote(w http.ResponseWriter, r *http.Request) {
 	user_id, ok := r.Context().Value(keyUserID).(int)
-	if !ok {
-		h.Errors(w, http.StatusForbidden, "You can't like post.")
+	if !ok || user_id == 0 {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
 		return
 	}
-	if user_id == 0 {
-		http.Redirect(w, r, "/signin", 303)
-	}
-
-	switch r.Method {
-	case "GET":
-		if err := h.services.Post.AddLikeByPost(postid, user_id); err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		http.Redirect(w, r, "post?id="+strconv.Itoa(postid), 303)
-	default:
-		h.Errors(w, http.StatusMethodNotAllowed, "")
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
 		return
 	}
-}
-func (h *Handler) likePostIndex(w http.ResponseWriter, r *http.Request) {
-	postid, err := strconv.Atoi(r.URL.Query().Get("postid"))
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
 	if err != nil {
-		h.Errors(w, http.StatusNotFound, "")
+		h.Errors(w, http.StatusBadRequest, "invalid id")
 		return
 	}
-	user_id, ok := r.Context().Value(keyUserID).(int)
-	if !ok {
-		h.Errors(w, http.StatusForbidden, "You can't like post.")
+	value := r.PostForm.Get("vote")
+	if value != module.VoteLike && value != module.VoteDislike {
+		h.Errors(w, http.StatusBadRequest, "invalid vote")
 		return
 	}
-	if user_id == 0 {
-		http.Redirect(w, r, "/signin", 303)
-	}
 
-	switch r.Method {
-	case "GET":
-		if err := h.services.Post.AddLikeByPost(postid, user_id); err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
+	var vote *module.Vote
+	postID := id
+	switch r.PostForm.Get("kind") {
+	case "post":
+		if _, err = h.services.Post.GetPostVote(id, user_id); err != nil {
+			break
 		}
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-	default:
-		h.Errors(w, http.StatusMethodNotAllowed, "")
-		return
-	}
-}
-func (h *Handler) likeComment(w http.ResponseWriter, r *http.Request) {
-	commentid, err := strconv.Atoi(r.URL.Query().Get("commentid"))
-	if err != nil {
-		h.Errors(w, http.StatusNotFound, "")
-		return
-	}
-	user_id, ok := r.Context().Value(keyUserID).(int)
-	if !ok {
-		h.Errors(w, http.StatusForbidden, "You can't like commnet")
-	}
-	if user_id == 0 {
-		http.Redirect(w, r, "/signin", 303)
-	}
-	switch r.Method {
-	case "GET":
-		if err := h.services.Comment.AddLikeByComment(commentid, user_id); err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
+		if value == module.VoteLike {
+			err = h.services.Post.AddLikeByPost(id, user_id)
+		} else {
+			err = h.services.Post.AddDislikeByPost(id, user_id)
 		}
-		postid, err := h.services.Comment.GetPostIdByCommentId(commentid)
 		if err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
+			break
 		}
-		http.Redirect(w, r, "post?id="+strconv.Itoa(postid.PostID), 303)
-	default:
-		h.Errors(w, http.StatusMethodNotAllowed, "")
-		return
-	}
-}
-
-func (h *Handler) dislikePost(w http.ResponseWriter, r *http.Request) {
-	postid, err := strconv.Atoi(r.URL.Query().Get("postid"))
-	if err != nil {
-		h.Errors(w, http.StatusNotFound, "")
-		return
-	}
-	user_id, ok := r.Context().Value(keyUserID).(int)
-	if !ok {
-		h.Errors(w, http.StatusForbidden, "You can't like post.")
-		return
-	}
-	if user_id == 0 {
-		http.Redirect(w, r, "/signin", 303)
-	}
-
-	switch r.Method {
-	case "GET":
-		if err := h.services.Post.AddDislikeByPost(postid, user_id); err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
+		vote, err = h.services.Post.GetPostVote(id, user_id)
+	case "comment":
+		var comment *module.Comment
+		if comment, err = h.services.Comment.GetPostIdByCommentId(id); err != nil {
+			break
+		}
+		postID = comment.PostID
+		if value == module.VoteLike {
+			err = h.services.Comment.AddLikeByComment(id, user_id)
+		} else {
+			err = h.services.Comment.AddDislikeByComment(id, user_id)
+		}
+		if err != nil {
+			break
 		}
-		http.Redirect(w, r, "post?id="+strconv.Itoa(postid), 303)
+		vote, err = h.services.Comment.GetCommentVote(id, user_id)
 	default:
-		h.Errors(w, http.StatusMethodNotAllowed, "")
+		h.Errors(w, http.StatusBadRequest, "invalid kind")
 		return
 	}
-}
-
-func (h *Handler) dislikePostIndex(w http.ResponseWriter, r *http.Request) {
-	postid, err := strconv.Atoi(r.URL.Query().Get("postid"))
-	if err != nil {
-		h.Errors(w, http.StatusNotFound, "")
+	if errors.Is(err, service.ErrPostNotFound) || errors.Is(err, service.ErrCommentNotFound) {
+		h.Errors(w, http.StatusNotFound, err.Error())
 		return
 	}
-	user_id, ok := r.Context().Value(keyUserID).(int)
-	if !ok {
-		h.Errors(w, http.StatusForbidden, "You can't like post.")
+	if err != nil {
+		log.Println("ERROR:delivery:vote: ", err)
+		h.Errors(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if user_id == 0 {
-		http.Redirect(w, r, "/signin", 303)
-	}
 
-	switch r.Method {
-	case "GET":
-		if err := h.services.Post.AddDislikeByPost(postid, user_id); err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(vote); err != nil {
+			log.Println("ERROR:delivery:vote:Encode: ", err)
 		}
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-	default:
-		h.Errors(w, http.StatusMethodNotAllowed, "")
 		return
 	}
+	next := r.PostForm.Get("next")
+	if !isLocalPath(next) {
+		next = "/post?id=" + strconv.Itoa(postID)
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
 }
 
-func (h *Handler) dislikeComment(w http.ResponseWriter, r *http.Request) {
-	commentid, err := strconv.Atoi(r.URL.Query().Get("commentid"))
-	if err != nil {
-		h.Errors(w, http.StatusNotFound, "")
-		return
-	}
-	user_id, ok := r.Context().Value(keyUserID).(int)
-	if !ok {
-		h.Errors(w, http.StatusForbidden, "You can't dislike commnet")
-	}
-	if user_id == 0 {
-		http.Redirect(w, r, "/signin", 303)
-	}
-	switch r.Method {
-	case "GET":
-		if err := h.services.Comment.AddDislikeByComment(commentid, user_id); err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		postid, err := h.services.Comment.GetPostIdByCommentId(commentid)
-		if err != nil {
-			h.Errors(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		log.Println(postid)
-		http.Redirect(w, r, "post?id="+strconv.Itoa(postid.PostID), 303)
-	default:
-		h.Errors(w, http.StatusMethodNotAllowed, "")
-		return
-	}
+// isLocalPath reports whether p is a path on this site, so redirecting to it
+// can't send the user elsewhere.
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
 }
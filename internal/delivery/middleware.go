@@ -2,9 +2,13 @@ package delivery
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -13,6 +17,10 @@ type key int
 
 const (
 	keyUserID key = iota
+	keyUser
+	keySession
+	keyAPIToken
+	keyCSRFToken
 )
 
 type (
@@ -35,22 +43,140 @@ func (c Chain) Then(handler http.Handler) http.Handler {
 	return handler
 }
 
+// authenticateUser puts the signed-in user into the request context: the id
+// under keyUserID (0 for guests) and the whole *module.User under keyUser.
+// Requests with an API token are authenticated by it instead of the cookie.
 func (h *Handler) authenticateUser(handler http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		u := module.User{}
-		c, err := r.Cookie("session")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				u.ID = 0
+		if token, ok := bearerToken(r); ok {
+			h.authenticateToken(w, r, token, handler)
+			return
+		}
+		var user *module.User
+		var session *module.Session
+		if token := h.sessionToken(r); token != "" {
+			var err error
+			if session, err = h.services.Auth.GetSession(token); err == nil {
+				user, err = h.services.GetUserByUserID(session.UserID)
+				if err != nil {
+					h.Errors(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+				if session.Renewed && session.Remember {
+					h.setSessionCookie(w, token, true)
+				}
+			} else {
+				session = nil
 			}
-			u.ID = 0
-		} else {
-			u.ID, err = h.services.GetUserIdByUUID(c.Value)
-			if err != nil {
-				u.ID = 0
+		}
+		userID := 0
+		if user != nil {
+			userID = user.ID
+			if r.URL.Path != "/settings/2fa" && h.services.MustEnrollTwoFactor(user) {
+				http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+				return
 			}
 		}
-		ctx := context.WithValue(r.Context(), keyUserID, u.ID)
+		ctx := context.WithValue(r.Context(), keyUserID, userID)
+		ctx = context.WithValue(ctx, keyUser, user)
+		ctx = context.WithValue(ctx, keySession, session)
 		handler(w, r.WithContext(ctx))
 	})
 }
+
+func (h *Handler) authenticateToken(w http.ResponseWriter, r *http.Request, token string, handler http.HandlerFunc) {
+	user, t, err := h.services.Auth.AuthenticateAPIToken(token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			h.Errors(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !tokenAllows(t, r) || h.services.MustEnrollTwoFactor(user) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		h.Errors(w, http.StatusForbidden, service.ErrAPITokenForbidden.Error())
+		return
+	}
+	ctx := context.WithValue(r.Context(), keyUserID, user.ID)
+	ctx = context.WithValue(ctx, keyUser, user)
+	ctx = context.WithValue(ctx, keyAPIToken, t)
+	handler(w, r.WithContext(ctx))
+}
+
+// currentUser returns the user put into the context by authenticateUser, or nil for guests.
+func currentUser(r *http.Request) *module.User {
+	user, _ := r.Context().Value(keyUser).(*module.User)
+	return user
+}
+
+// currentSession returns the session the request was authenticated with, or
+// nil for guests and API tokens.
+func currentSession(r *http.Request) *module.Session {
+	session, _ := r.Context().Value(keySession).(*module.Session)
+	return session
+}
+
+// requireRole lets through only users with the role or a higher one.
+// It must be wrapped by authenticateUser.
+func (h *Handler) requireRole(role string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := currentUser(r)
+			if user == nil {
+				http.Redirect(w, r, "/signin", http.StatusSeeOther)
+				return
+			}
+			if !user.HasRole(role) {
+				h.Errors(w, http.StatusForbidden, service.ErrForbidden.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireVerified lets through only signed-in users who confirmed their email.
+// It must be wrapped by authenticateUser.
+func (h *Handler) requireVerified(handler http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if user == nil {
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		if !user.Verified {
+			h.Errors(w, http.StatusForbidden, service.ErrNotVerified.Error())
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// allowMethods answers 405 to any method not listed, before authentication
+// gets a chance to redirect.
+func (h *Handler) allowMethods(handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				handler(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	})
+}
+
+func clientOf(r *http.Request) module.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return module.Client{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
@@ -0,0 +1,49 @@
+package delivery
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// Listings are paged with ?after= and ?before=, each holding a cursor.
+const (
+	afterParam  = "after"
+	beforeParam = "before"
+)
+
+// pageQuery reads the cursor of a listing from query and removes it, so the
+// rest can be read as filters.
+func (h *Handler) pageQuery(query url.Values) (module.PageQuery, error) {
+	q := module.PageQuery{Limit: h.cfg.PageSize}
+	var err error
+	if v := query.Get(afterParam); v != "" {
+		if q.After, err = module.ParseCursor(v); err != nil {
+			return q, err
+		}
+	} else if v := query.Get(beforeParam); v != "" {
+		if q.Before, err = module.ParseCursor(v); err != nil {
+			return q, err
+		}
+	}
+	query.Del(afterParam)
+	query.Del(beforeParam)
+	return q, nil
+}
+
+// pageLinks turns the cursors of p into links to those pages, keeping the
+// other parameters of the request.
+func pageLinks(r *http.Request, p module.Pagination) (next, prev string) {
+	link := func(key, cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		query := r.URL.Query()
+		query.Del(afterParam)
+		query.Del(beforeParam)
+		query.Set(key, cursor)
+		return r.URL.Path + "?" + query.Encode()
+	}
+	return link(afterParam, p.Next), link(beforeParam, p.Prev)
+}
@@ -3,13 +3,14 @@ package delivery
 import (
 	"database/sql"
 	"errors"
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ive663/forum/internal/config"
+	"github.com/ive663/forum/internal/markdown"
 	"github.com/ive663/forum/internal/module"
 	"github.com/ive663/forum/internal/service"
 )
@@ -31,7 +32,7 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case "GET":
-		t, err := template.ParseFiles("templates/post.html")
+		t, err := parseTemplate(r, "templates/post.html")
 		if err != nil {
 			log.Print(err)
 			h.Errors(w, http.StatusInternalServerError, err.Error())
@@ -39,13 +40,17 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 		}
 		post, err := h.services.GetPostByPostId(postid)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
+			if errors.Is(err, service.ErrPostNotFound) {
 				h.Errors(w, http.StatusNotFound, err.Error())
 				return
 			}
 			h.Errors(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		if !post.VisibleTo(currentUser(r)) {
+			h.Errors(w, http.StatusNotFound, service.ErrPostNotFound.Error())
+			return
+		}
 		postlikes, err := h.services.GetLikesCountByPostID(postid)
 		if err != nil {
 			h.Errors(w, http.StatusInternalServerError, err.Error())
@@ -56,7 +61,12 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 			h.Errors(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		comment, err := h.services.GetComments(post.ID)
+		page, err := h.pageQuery(r.URL.Query())
+		if err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		comment, pagination, err := h.services.GetComments(post.ID, page)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				h.Errors(w, http.StatusNotFound, err.Error())
@@ -83,7 +93,9 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 			CommentsDislikes: commentdislikes,
 			Comments:         comment.PrepToView(),
 			Authorization:    user_authorization,
+			User:             currentUser(r),
 		}
+		pageContent.NextComments, pageContent.PrevComments = pageLinks(r, pagination)
 
 		if err := t.Execute(w, pageContent); err != nil {
 			h.Errors(w, http.StatusInternalServerError, err.Error())
@@ -107,6 +119,14 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 				h.Errors(w, http.StatusInternalServerError, err.Error())
 				return
 			}
+			if !author.Verified {
+				h.Errors(w, http.StatusForbidden, service.ErrNotVerified.Error())
+				return
+			}
+			if post, err := h.services.GetPostByPostId(postid); err != nil || post.Deleted() || post.Scheduled() {
+				h.Errors(w, http.StatusNotFound, service.ErrPostNotFound.Error())
+				return
+			}
 			newComment := &module.Comment{
 				AuthorID: user_id,
 				Author:   author.Login,
@@ -119,22 +139,31 @@ func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 					h.Errors(w, http.StatusBadRequest, err.Error())
 					return
 				}
+				log.Println("ERROR:delivery:post:CreateComment: ", err)
+				h.Errors(w, http.StatusInternalServerError, err.Error())
+				return
 			}
 			http.Redirect(w, r, "post?id="+strconv.Itoa(postid), http.StatusSeeOther)
-		}
-		tmpl, err := template.ParseFiles("./templates/post.html")
-		if err != nil {
-			h.Errors(w, http.StatusInternalServerError, "Error in parsing")
-			log.Print(err)
 			return
 		}
-		if err = tmpl.Execute(w, nil); err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error in execute")
-		}
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
 	}
 }
 
+// createPostPage holds the form as the user filled it in, so that it can be
+// shown again with the error or continued from a draft.
+type createPostPage struct {
+	Title      string
+	Message    string
+	Categories []string
+	categoryPicker
+	DraftID   int
+	PublishAt string
+	TZ        string
+	Error     string
+	Uploads   config.Uploads
+}
+
 func (h *Handler) createpost(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/createpost" {
 		h.Errors(w, http.StatusNotFound, "")
@@ -149,74 +178,343 @@ func (h *Handler) createpost(w http.ResponseWriter, r *http.Request) {
 		h.Errors(w, http.StatusForbidden, "")
 		return
 	}
+	page := createPostPage{Uploads: h.cfg.Uploads}
 	switch r.Method {
 	case "GET":
-		t, err := template.ParseFiles("templates/createpost.html")
-		if err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
-			return
-		}
-		if err = t.Execute(w, nil); err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error executing")
-			return
+		if v := r.URL.Query().Get("draft"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				h.Errors(w, http.StatusNotFound, service.ErrDraftNotFound.Error())
+				return
+			}
+			draft, err := h.services.GetDraft(user_id, id)
+			if err != nil {
+				if errors.Is(err, service.ErrDraftNotFound) {
+					h.Errors(w, http.StatusNotFound, err.Error())
+					return
+				}
+				h.Errors(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			page.Title, page.Message, page.Categories, page.DraftID = draft.Title, draft.Message, strings.Fields(draft.Tags), draft.ID
 		}
+		h.renderCreatePost(w, r, http.StatusOK, page)
 	case "POST":
 		user, err := h.services.GetUserByUserID(user_id)
 		if err != nil {
 			h.Errors(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if err = r.ParseForm(); err != nil {
+		if err = h.parseForm(w, r); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				h.Errors(w, http.StatusRequestEntityTooLarge, errUploadTooLarge)
+				return
+			}
 			log.Print(err)
 			h.Errors(w, http.StatusBadRequest, "Error parsing")
 			return
 		}
-		title, ok := r.Form["title"]
-		if !ok {
-			h.Errors(w, http.StatusBadRequest, "Bad typing title")
-			return
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
 		}
-		message, ok := r.Form["message"]
-		if !ok {
-			h.Errors(w, http.StatusBadRequest, "Bad typing message")
+		uploads, err := readUploads(r)
+		if err != nil {
+			log.Println("ERROR:delivery:createpost:readUploads: ", err)
+			h.Errors(w, http.StatusBadRequest, "Error reading the images")
 			return
 		}
-		titlecategory, ok := r.Form["category"]
-		tags := strings.Fields(strings.Join(titlecategory, " "))
-		if !ok {
-			h.Errors(w, http.StatusBadRequest, "Bad typing title category")
+		page.Title = r.PostForm.Get("title")
+		page.Message = r.PostForm.Get("message")
+		page.Categories = categoryValues(r.PostForm)
+		page.PublishAt = r.PostForm.Get("publish_at")
+		page.TZ = r.PostForm.Get("tz")
+		page.DraftID, _ = strconv.Atoi(r.PostForm.Get("draft"))
+		publishAt, err := parsePublishAt(page.PublishAt, page.TZ)
+		if err != nil {
+			page.Error = "Publish time is not a valid date and time"
+			h.renderCreatePost(w, r, http.StatusBadRequest, page)
+			return
 		}
 		newPost := &module.Post{
-			Title:    title[0],
-			Message:  message[0],
-			AuthorID: user.ID,
-			Author:   user.Login,
-			Date:     time.Now(),
+			Title:     page.Title,
+			Message:   page.Message,
+			AuthorID:  user.ID,
+			Author:    user.Login,
+			Date:      time.Now(),
+			PublishAt: publishAt,
 		}
-		err = h.services.CreatePost(newPost, tags)
+		err = h.services.CreatePost(newPost, page.Categories, uploads)
 		if err != nil {
-			if errors.Is(err, service.ErrEmptyValue) || errors.Is(err, service.ErrInvalidTypingPost) {
-				h.Errors(w, http.StatusBadRequest, err.Error())
+			if errors.Is(err, service.ErrEmptyValue) || errors.Is(err, service.ErrInvalidTypingPost) || errors.Is(err, service.ErrInvalidTypingCategory) || errors.Is(err, service.ErrInvalidAttachment) || errors.Is(err, service.ErrInvalidPublishTime) {
+				// the images are not kept, they have to be picked again
+				page.Error = err.Error()
+				h.renderCreatePost(w, r, http.StatusBadRequest, page)
 				return
 			}
 			h.Errors(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		t, err := template.ParseFiles("templates/createpost.html")
-		if err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error Parsing")
+		if page.DraftID != 0 {
+			if err := h.services.DeleteDraft(user_id, page.DraftID); err != nil && !errors.Is(err, service.ErrDraftNotFound) {
+				log.Println("ERROR:delivery:createpost:DeleteDraft: ", err)
+			}
 		}
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		if err = t.Execute(w, nil); err != nil {
-			log.Print(err)
-			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		if newPost.Scheduled() {
+			http.Redirect(w, r, "/drafts", http.StatusSeeOther)
 			return
 		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 	default:
 		h.Errors(w, http.StatusMethodNotAllowed, "")
 		return
 	}
 }
+
+func (h *Handler) renderCreatePost(w http.ResponseWriter, r *http.Request, status int, page createPostPage) {
+	var err error
+	if page.categoryPicker, err = h.newCategoryPicker(page.Categories); err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t, err := parseTemplate(r, "templates/createpost.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, page); err != nil {
+		log.Println("ERROR:delivery:createpost: ", err)
+	}
+}
+
+// deleteComment removes a comment; authors may delete their own and moderators any.
+func (h *Handler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	comment, err := h.services.Comment.DeleteComment(user, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrForbidden):
+			h.Errors(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrCommentNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	http.Redirect(w, r, "/post?id="+strconv.Itoa(comment.PostID), http.StatusSeeOther)
+}
+
+type editPostPage struct {
+	Post *module.Post
+	categoryPicker
+	Error string
+}
+
+// editPost shows the edit form of a post and saves it. Every save becomes a
+// revision in the post history.
+func (h *Handler) editPost(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	post, err := h.services.GetPostByPostId(id)
+	if err != nil {
+		if errors.Is(err, service.ErrPostNotFound) {
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if post.Deleted() {
+		h.Errors(w, http.StatusNotFound, service.ErrPostNotFound.Error())
+		return
+	}
+	if !post.CanEdit(user) {
+		h.Errors(w, http.StatusForbidden, service.ErrForbidden.Error())
+		return
+	}
+	page := editPostPage{Post: post}
+	status := http.StatusOK
+	var picked []string
+	for _, c := range post.Categories {
+		picked = append(picked, c.Slug)
+	}
+	if r.Method == http.MethodPost {
+		edited := &module.Post{
+			ID:      post.ID,
+			Title:   r.PostForm.Get("title"),
+			Message: r.PostForm.Get("message"),
+		}
+		picked = categoryValues(r.PostForm)
+		err := h.services.EditPost(user, edited, picked)
+		switch {
+		case err == nil:
+			http.Redirect(w, r, "/post?id="+strconv.Itoa(post.ID), http.StatusSeeOther)
+			return
+		case errors.Is(err, service.ErrEmptyValue) || errors.Is(err, service.ErrInvalidTypingPost) || errors.Is(err, service.ErrInvalidTypingCategory):
+			page.Post, page.Error = edited, err.Error()
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrForbidden):
+			h.Errors(w, http.StatusForbidden, err.Error())
+			return
+		case errors.Is(err, service.ErrPostNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if page.categoryPicker, err = h.newCategoryPicker(picked); err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t, err := parseTemplate(r, "templates/editpost.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, page); err != nil {
+		log.Println("ERROR:delivery:editPost: ", err)
+	}
+}
+
+// deletePost hides a post; authors may delete their own and moderators any.
+func (h *Handler) deletePost(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := h.services.Post.DeletePost(user, id); err != nil {
+		switch {
+		case errors.Is(err, service.ErrForbidden):
+			h.Errors(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrPostNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+type postHistoryPage struct {
+	*module.PostHistory
+	Authorization bool
+}
+
+// postHistory shows the revisions of a post and what changed between two of
+// them, picked with from and to.
+func (h *Handler) postHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, err := strconv.Atoi(q.Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	var from, to int
+	if v := q.Get("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			h.Errors(w, http.StatusBadRequest, service.ErrInvalidQueryRequest.Error())
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			h.Errors(w, http.StatusBadRequest, service.ErrInvalidQueryRequest.Error())
+			return
+		}
+	}
+	history, err := h.services.GetPostHistory(id, from, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPostNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrInvalidQueryRequest):
+			h.Errors(w, http.StatusBadRequest, err.Error())
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	user := currentUser(r)
+	if !history.Post.VisibleTo(user) {
+		h.Errors(w, http.StatusNotFound, service.ErrPostNotFound.Error())
+		return
+	}
+	t, err := parseTemplate(r, "templates/post_history.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	if err := t.Execute(w, postHistoryPage{PostHistory: history, Authorization: user != nil}); err != nil {
+		log.Println("ERROR:delivery:postHistory: ", err)
+	}
+}
+
+// maxPreviewBytes bounds the text sent for a preview.
+const maxPreviewBytes = 64 << 10
+
+// previewMarkdown renders the message field the way the post page would, for
+// the live preview next to the editor.
+func (h *Handler) previewMarkdown(w http.ResponseWriter, r *http.Request) {
+	if currentUser(r) == nil {
+		h.Errors(w, http.StatusUnauthorized, "")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreviewBytes)
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.Errors(w, http.StatusRequestEntityTooLarge, "the text is too long to preview")
+			return
+		}
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte(markdown.Render(r.PostForm.Get("message")))); err != nil {
+		log.Println("ERROR:delivery:previewMarkdown: ", err)
+	}
+}
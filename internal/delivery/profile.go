@@ -1,43 +1,96 @@
 package delivery
 
-// import (
-// 	"log"
-// 	"net/http"
-// 	"text/template"
-// )
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
-// func (h *Handler) profile(w http.ResponseWriter, r *http.Request) {
-// 	if r.Method != "GET" {
-// 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-// 		return
-// 	}
-// 	user_is_authoryz, ok := r.Context().Value(keyUserBool).(bool)
-// 	if !ok {
-// 		h.Errors(w, http.StatusForbidden, "not authorized")
-// 		return
-// 	}
-// 	if r.Method == "GET" {
-// 		t, err := template.ParseFiles("./templates/profile.html")
-// 		if err != nil {
-// 			log.Print(err)
-// 			http.Error(w, err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
-// 		cookie, err := r.Cookie("session")
-// 		if err!= nil {
-//             log.Print(err)
-// 			http.Error(w, err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
-// 		user, err := h.services.ParseSessionToken(cookie.Value)
-// 		if err!= nil {
-//             log.Print(err)
-// 			http.Error(w, err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
-// 		if user_is_authoryz {
-// 			module.G
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
 
-// 		}
-// 	}
-// }
+// profile shows /user/{login}. ?tab=comments switches from posts to
+// comments and ?page= pages through them.
+func (h *Handler) profile(w http.ResponseWriter, r *http.Request) {
+	login := strings.TrimPrefix(r.URL.Path, "/user/")
+	if login == "" || strings.Contains(login, "/") {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	tab := r.URL.Query().Get("tab")
+	if tab == "" {
+		tab = "posts"
+	}
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			h.Errors(w, http.StatusNotFound, "Invalid query request")
+			return
+		}
+		page = n
+	}
+	h.renderProfile(w, r, login, tab, page, http.StatusOK, "")
+}
+
+func (h *Handler) renderProfile(w http.ResponseWriter, r *http.Request, login, tab string, page, status int, message string) {
+	p, err := h.services.Profile.GetProfile(login, tab, page)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrProfileNotFound):
+			h.Errors(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrInvalidQueryRequest):
+			h.Errors(w, http.StatusBadRequest, err.Error())
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	viewer := currentUser(r)
+	p.Authorization = viewer != nil
+	p.Own = viewer != nil && viewer.ID == p.User.ID
+	p.Error = message
+	t, err := parseTemplate(r, "templates/profile.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, p); err != nil {
+		log.Print(err)
+	}
+}
+
+// editProfile saves the bio and avatar of the signed-in user.
+func (h *Handler) editProfile(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.services.Profile.UpdateProfile(user.ID, r.PostForm.Get("bio"), r.PostForm.Get("avatar")); err != nil {
+		if errors.Is(err, service.ErrInvalidBio) || errors.Is(err, service.ErrInvalidAvatar) {
+			h.renderProfile(w, r, user.Login, "posts", 1, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, profileURL(user), http.StatusSeeOther)
+}
+
+func profileURL(u *module.User) string {
+	return "/user/" + url.PathEscape(u.Login)
+}
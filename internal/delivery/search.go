@@ -0,0 +1,92 @@
+package delivery
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+type searchPage struct {
+	Form          module.SearchForm
+	Results       []module.SearchResult
+	Searched      bool
+	Error         string
+	Next          string
+	Prev          string
+	Authorization bool
+}
+
+// search finds posts and comments. The page of results is given by ?page=:
+// the order of the results moves as posts are written and voted on, so there
+// is no cursor to keep.
+func (h *Handler) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	form := module.SearchForm{
+		Query:  query.Get("q"),
+		Author: query.Get("author"),
+		Tag:    query.Get("tag"),
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+		Page:   1,
+	}
+	if v := query.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			h.Errors(w, http.StatusBadRequest, "Invalid page")
+			return
+		}
+		form.Page = page
+	}
+	data := searchPage{Form: form, Authorization: currentUser(r) != nil}
+	status := http.StatusOK
+	if strings.TrimSpace(form.Query) != "" {
+		data.Searched = true
+		results, err := h.services.Search.Search(form, h.cfg.PageSize)
+		switch {
+		case errors.Is(err, service.ErrSearchUnavailable):
+			status, data.Error = http.StatusServiceUnavailable, err.Error()
+		case errors.Is(err, service.ErrEmptySearch), errors.Is(err, service.ErrInvalidSearch), errors.Is(err, service.ErrInvalidSearchDate):
+			status, data.Error = http.StatusBadRequest, err.Error()
+		case err != nil:
+			h.Errors(w, http.StatusInternalServerError, "Error searching")
+			return
+		default:
+			data.Results = results.Results
+			if results.More {
+				data.Next = searchPageLink(r, results.Page+1)
+			}
+			if results.Page > 1 {
+				data.Prev = searchPageLink(r, results.Page-1)
+			}
+		}
+	}
+	t, err := parseTemplate(r, "templates/search.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, data); err != nil {
+		log.Println("ERROR:delivery:search: ", err)
+	}
+}
+
+func searchPageLink(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return r.URL.Path + "?" + query.Encode()
+}
+
+var matchMarks = strings.NewReplacer(module.MatchStart, "<mark>", module.MatchEnd, "</mark>")
+
+// highlight escapes text found by a search and shows what matched with <mark>.
+func highlight(text string) template.HTML {
+	return template.HTML(matchMarks.Replace(template.HTMLEscapeString(text)))
+}
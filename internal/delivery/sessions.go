@@ -0,0 +1,91 @@
+package delivery
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+type sessionsPage struct {
+	Sessions      []module.Session
+	Authorization bool
+}
+
+func (h *Handler) sessions(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/sessions" {
+		h.Errors(w, http.StatusNotFound, "")
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	user_id, ok := r.Context().Value(keyUserID).(int)
+	if !ok || user_id == 0 {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	current := currentSession(r)
+	if current == nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	sessions, err := h.services.Auth.GetSessions(user_id, current.UUID)
+	if err != nil {
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t, err := parseTemplate(r, "templates/sessions.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	if err := t.Execute(w, sessionsPage{Sessions: sessions, Authorization: true}); err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error executing")
+	}
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	user_id, ok := r.Context().Value(keyUserID).(int)
+	if !ok || user_id == 0 {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.Errors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.PostForm.Get("all") != "" {
+		if err := h.services.Auth.RevokeAllSessions(user_id); err != nil {
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h.clearCookie(w, sessionCookie, "/")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		h.Errors(w, http.StatusBadRequest, "")
+		return
+	}
+	if err := h.services.Auth.RevokeSession(user_id, id); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			h.Errors(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.Errors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
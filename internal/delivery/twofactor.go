@@ -0,0 +1,139 @@
+package delivery
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/service"
+)
+
+type twoFactorPage struct {
+	*module.TwoFactor
+	// otpauth:// links are not on html/template's list of safe schemes
+	URI template.URL
+}
+
+func (h *Handler) signinSecondFactor(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(challengeCookie)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		t, err := parseTemplate(r, "templates/signin2fa.html")
+		if err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+			return
+		}
+		if err = t.Execute(w, nil); err != nil {
+			log.Print(err)
+			h.Errors(w, http.StatusInternalServerError, "Error executing")
+		}
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		token, remember, err := h.services.Auth.CompleteSignIn(c.Value, r.PostForm.Get("code"), clientOf(r))
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidCode) {
+				h.Errors(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			if errors.Is(err, service.ErrTooManyAttempts) {
+				h.Errors(w, http.StatusTooManyRequests, err.Error())
+				return
+			}
+			if errors.Is(err, service.ErrInvalidToken) {
+				h.Errors(w, http.StatusUnauthorized, "Sign-in took too long, please start again")
+				return
+			}
+			log.Println("ERROR:delivery:Auth:signinSecondFactor:CompleteSignIn: ", err)
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h.clearCookie(w, challengeCookie, "/signin")
+		h.setSessionCookie(w, token, remember)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+func (h *Handler) twoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := r.Context().Value(keyUserID).(int)
+	if !ok || user_id == 0 {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	var (
+		tf  *module.TwoFactor
+		err error
+	)
+	switch r.Method {
+	case "GET":
+		tf, err = h.services.Auth.GetTwoFactor(user_id)
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			h.Errors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		code := r.PostForm.Get("code")
+		switch r.PostForm.Get("action") {
+		case "begin":
+			tf, err = h.services.Auth.BeginTOTPEnrollment(user_id)
+		case "confirm":
+			var codes []string
+			if codes, err = h.services.Auth.ConfirmTOTPEnrollment(user_id, code); err == nil {
+				tf = &module.TwoFactor{Enabled: true, RecoveryCodes: codes, RecoveryCodesLeft: len(codes)}
+				r, err = h.rotateSession(w, r)
+			}
+		case "recovery":
+			var codes []string
+			if codes, err = h.services.Auth.RegenerateRecoveryCodes(user_id, code, clientOf(r)); err == nil {
+				tf = &module.TwoFactor{Enabled: true, RecoveryCodes: codes, RecoveryCodesLeft: len(codes)}
+			}
+		case "disable":
+			if err = h.services.Auth.DisableTOTP(user_id, r.PostForm.Get("password"), code, clientOf(r)); err == nil {
+				if r, err = h.rotateSession(w, r); err == nil {
+					http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+					return
+				}
+			}
+		default:
+			h.Errors(w, http.StatusBadRequest, "")
+			return
+		}
+	default:
+		h.Errors(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidCode), errors.Is(err, service.ErrReauthFailed):
+			h.Errors(w, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, service.ErrTooManyAttempts):
+			h.Errors(w, http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, service.ErrTOTPEnabled), errors.Is(err, service.ErrTOTPDisabled), errors.Is(err, service.ErrTOTPMandatory):
+			h.Errors(w, http.StatusConflict, err.Error())
+		default:
+			h.Errors(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	t, err := parseTemplate(r, "templates/twofactor.html")
+	if err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error parsing file")
+		return
+	}
+	if err = t.Execute(w, twoFactorPage{tf, template.URL(tf.URI)}); err != nil {
+		log.Print(err)
+		h.Errors(w, http.StatusInternalServerError, "Error executing")
+	}
+}
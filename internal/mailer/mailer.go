@@ -0,0 +1,100 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ive663/forum/internal/config"
+)
+
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+func New(cfg config.Mail) Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword, cfg.From)
+	default:
+		return NewLogMailer(cfg.File, cfg.From)
+	}
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, message(m.from, to, subject, body)); err != nil {
+		log.Println("Error:mailer:smtp:Send: ", err)
+		return err
+	}
+	return nil
+}
+
+// LogMailer writes outgoing mail to a file, or to the log when no file is set.
+// It is meant for local development where no SMTP server is available.
+type LogMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewLogMailer(path, from string) *LogMailer {
+	return &LogMailer{
+		path: path,
+		from: from,
+	}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	msg := message(m.from, to, subject, body)
+	if m.path == "" {
+		log.Printf("mailer:log:Send:\n%s\n", msg)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Println("Error:mailer:log:Send: OpenFile: ", err)
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s\n\n", msg); err != nil {
+		log.Println("Error:mailer:log:Send: Write: ", err)
+		return err
+	}
+	return nil
+}
+
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
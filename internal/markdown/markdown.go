@@ -0,0 +1,50 @@
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// converter leaves raw HTML out of its output; the policy then drops anything
+// else it doesn't know, so a bug in either alone can't let markup through.
+var (
+	converter = goldmark.New(
+		goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+		goldmark.WithRendererOptions(html.WithHardWraps()),
+	)
+	policy = newPolicy()
+)
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6",
+		"ul", "ol", "li", "blockquote", "pre", "code", "em", "strong", "del",
+	)
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	return p
+}
+
+// Render turns Markdown into HTML that only uses the allowed elements and
+// attributes. Line breaks are kept as they were typed.
+func Render(src string) template.HTML {
+	var buf bytes.Buffer
+	if err := converter.Convert([]byte(src), &buf); err != nil {
+		log.Println("ERROR:markdown:Render: ", err)
+		return template.HTML("<p>" + template.HTMLEscapeString(src) + "</p>")
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
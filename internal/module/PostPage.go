@@ -9,5 +9,13 @@ type PostPage struct {
 	CommentsLikes    map[int][]int
 	CommentsDislikes map[int][]int
 	Authorization    bool
+	User             *User
+	// links to the next and previous pages of comments
+	NextComments string
+	PrevComments string
 }
 
+// CanDelete reports whether the viewer may delete a comment by authorID.
+func (p PostPage) CanDelete(authorID int) bool {
+	return p.User != nil && (p.User.ID == authorID || p.User.HasRole(RoleModerator))
+}
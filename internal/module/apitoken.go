@@ -0,0 +1,72 @@
+package module
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead = "read"
+	ScopePost = "post"
+	ScopeVote = "vote"
+)
+
+var Scopes = []string{ScopeRead, ScopePost, ScopeVote}
+
+// APIToken lets scripts act on behalf of a user through the
+// Authorization: Bearer header. Only the hash of the token is stored.
+type APIToken struct {
+	ID        int
+	UserID    int
+	Name      string
+	TokenHash string
+	Scopes    []string
+	CreatedAt time.Time
+	// ExpiresAt is zero for tokens that never expire.
+	ExpiresAt time.Time
+	LastUsed  time.Time
+}
+
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) Expired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+func (t *APIToken) ScopesFormat() string {
+	return strings.Join(t.Scopes, ", ")
+}
+
+func (t *APIToken) CreatedFormat() string {
+	return t.CreatedAt.Format("02.01.2006 15:04")
+}
+
+func (t *APIToken) ExpiresFormat() string {
+	if t.ExpiresAt.IsZero() {
+		return "never"
+	}
+	return t.ExpiresAt.Format("02.01.2006 15:04")
+}
+
+func (t *APIToken) LastUsedFormat() string {
+	if t.LastUsed.IsZero() {
+		return "never"
+	}
+	return t.LastUsed.Format("02.01.2006 15:04")
+}
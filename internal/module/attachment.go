@@ -0,0 +1,35 @@
+package module
+
+import (
+	"strconv"
+	"time"
+)
+
+// Attachment is an image attached to a post. The image and its thumbnail are
+// kept in the blob store under Key and ThumbKey.
+type Attachment struct {
+	ID          int
+	PostID      int
+	Key         string
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+	ThumbKey    string
+	ThumbType   string
+	Date        time.Time
+}
+
+func (a *Attachment) URL() string {
+	return "/attachment?id=" + strconv.Itoa(a.ID)
+}
+
+func (a *Attachment) ThumbURL() string {
+	return a.URL() + "&size=thumb"
+}
+
+// Upload is a file as the user sent it, before any check.
+type Upload struct {
+	Name string
+	Data []byte
+}
@@ -1,8 +1,50 @@
 package module
 
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultCategoryColor is the color of categories that weren't given one.
+const DefaultCategoryColor = "#50FA7B"
+
+// Category is one of the categories administrators keep for posts. Posts
+// link to it by Slug. PostCount and LatestActivity count published posts
+// only, and are filled in where categories are listed.
 type Category struct {
-	ID     int
-	PostID int
-	Tag  string
-	Posts  []Post
+	ID             int
+	Name           string
+	Slug           string
+	Description    string
+	Color          string
+	Position       int
+	PostCount      int
+	LatestActivity time.Time
+}
+
+func (c Category) LatestActivityFormat() string {
+	if c.LatestActivity.IsZero() {
+		return ""
+	}
+	return c.LatestActivity.Format("02.01.2006 15:04")
+}
+
+// Slugify makes the slug of a category name: lower case letters and digits,
+// in any script, with a dash wherever anything else was.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
 }
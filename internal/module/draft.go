@@ -0,0 +1,19 @@
+package module
+
+import "time"
+
+// Draft is a post that is still being written. Tags holds the slugs of the
+// picked categories, separated by spaces.
+type Draft struct {
+	ID        int
+	UserID    int
+	Title     string
+	Message   string
+	Tags      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (d *Draft) UpdatedFormat() string {
+	return d.UpdatedAt.Format("02.01.2006 15:04")
+}
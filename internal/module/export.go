@@ -0,0 +1,81 @@
+package module
+
+import "time"
+
+// Export is everything the forum keeps about a user, as handed out by
+// "download my data". Password hashes and 2FA secrets are left out.
+type Export struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	Profile    ExportProfile   `json:"profile"`
+	Posts      []ExportPost    `json:"posts"`
+	Comments   []ExportComment `json:"comments"`
+	Votes      []ExportVote    `json:"votes"`
+	Drafts     []ExportDraft   `json:"drafts"`
+	Sessions   []ExportSession `json:"sessions"`
+	APITokens  []ExportToken   `json:"api_tokens"`
+}
+
+type ExportProfile struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Verified  bool      `json:"verified"`
+	Role      string    `json:"role"`
+	TwoFactor bool      `json:"two_factor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportPost struct {
+	ID         int      `json:"id"`
+	Title      string   `json:"title"`
+	Message    string   `json:"message"`
+	Categories []string `json:"categories"`
+	// Attachments are links to the images attached to the post.
+	Attachments []string   `json:"attachments,omitempty"`
+	Likes       int        `json:"likes"`
+	Dislikes    int        `json:"dislikes"`
+	Date        time.Time  `json:"date"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+}
+
+type ExportComment struct {
+	ID       int       `json:"id"`
+	PostID   int       `json:"post_id"`
+	Message  string    `json:"message"`
+	Likes    int       `json:"likes"`
+	Dislikes int       `json:"dislikes"`
+	Date     time.Time `json:"date"`
+}
+
+type ExportDraft struct {
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	Tags      string    `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportVote is a like or dislike; exactly one of PostID and CommentID is set.
+type ExportVote struct {
+	Vote      string `json:"vote"`
+	PostID    int    `json:"post_id,omitempty"`
+	CommentID int    `json:"comment_id,omitempty"`
+}
+
+type ExportSession struct {
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ExportToken struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
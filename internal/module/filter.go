@@ -0,0 +1,36 @@
+package module
+
+import "time"
+
+// PostFilter narrows a post listing down; a post must match every filter
+// that is set. Mine, Liked, Disliked and Commented are about UserID, the
+// user the listing is shown to. From and To are dates as the form sends
+// them, both included; Since and Until are worked out from them by the
+// service, Until exclusive.
+type PostFilter struct {
+	Categories []string
+	// AllCategories asks for posts with every one of Categories rather
+	// than any of them.
+	AllCategories bool
+	Mine          bool
+	Liked         bool
+	Disliked      bool
+	Commented     bool
+	NoComments    bool
+	From          string
+	To            string
+	Since         time.Time
+	Until         time.Time
+	UserID        int
+}
+
+// Personal reports whether the filter is about the user the listing is
+// shown to.
+func (f PostFilter) Personal() bool {
+	return f.Mine || f.Liked || f.Disliked || f.Commented
+}
+
+// Empty reports whether the filter lets every post through.
+func (f PostFilter) Empty() bool {
+	return len(f.Categories) == 0 && !f.Personal() && !f.NoComments && f.From == "" && f.To == ""
+}
@@ -0,0 +1,67 @@
+package module
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a listing: the date and id of a row, and its score
+// in listings ranked by one. Listings are ordered by all of them, so rows
+// added between two page loads don't shift the pages, and rows with the same
+// score or date keep their order.
+type Cursor struct {
+	Score float64
+	Date  time.Time
+	ID    int
+}
+
+// String encodes the cursor for a URL.
+func (c Cursor) String() string {
+	s := strconv.FormatInt(c.Date.UnixNano(), 36) + "." + strconv.Itoa(c.ID)
+	if c.Score != 0 {
+		s += "." + strconv.FormatFloat(c.Score, 'g', -1, 64)
+	}
+	return s
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return nil, ErrInvalidCursor
+	}
+	nsec, err := strconv.ParseInt(parts[0], 36, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n < 0 {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{Date: time.Unix(0, nsec), ID: n}
+	if len(parts) == 3 {
+		if c.Score, err = strconv.ParseFloat(parts[2], 64); err != nil || math.IsNaN(c.Score) || math.IsInf(c.Score, 0) {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return c, nil
+}
+
+// PageQuery asks for Limit rows that come after After in the listing, or
+// before Before when that is set instead. With neither it is the first page.
+type PageQuery struct {
+	After  *Cursor
+	Before *Cursor
+	Limit  int
+}
+
+// Pagination holds the cursors of the pages next to the one shown; an empty
+// one means there is no such page.
+type Pagination struct {
+	Next string
+	Prev string
+}
@@ -0,0 +1,52 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursors := []Cursor{
+		{Date: time.Unix(1700000000, 123456789), ID: 42},
+		{Date: time.Unix(0, 0), ID: 0},
+		{Date: time.Unix(-5, 0), ID: 7},
+		{Score: 1.5, Date: time.Unix(1700000000, 0), ID: 3},
+		{Score: -0.25e-10, Date: time.Unix(1, 1), ID: 9},
+	}
+	for _, c := range cursors {
+		got, err := ParseCursor(c.String())
+		if err != nil {
+			t.Errorf("ParseCursor(%q): %v", c.String(), err)
+			continue
+		}
+		if got.Score != c.Score || !got.Date.Equal(c.Date) || got.ID != c.ID {
+			t.Errorf("ParseCursor(%q) = %+v, want %+v", c.String(), *got, c)
+		}
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"abc",
+		".",
+		"abc.",
+		".12",
+		"abc.-1",
+		"abc.1x",
+		"abc.99999999999999999999",
+		"zzzzzzzzzzzzzzzz.1",
+		"a!c.1",
+		"abc.1.",
+		"abc.1.x",
+		"abc.1.NaN",
+		"abc.1.Inf",
+		"abc.1.-Inf",
+		"abc.1.1e999",
+		"abc.1.2.3.4",
+	} {
+		if c, err := ParseCursor(s); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) = %+v, %v, want %v", s, c, err, ErrInvalidCursor)
+		}
+	}
+}
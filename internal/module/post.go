@@ -3,32 +3,72 @@ package module
 import "time"
 
 type Post struct {
-	ID         int
-	Title      string
-	AuthorID   int
-  Author     string
-	Message    string
-  Likes      int
-  Dislikes   int
-	Liked      bool
-  CategoryID int
-	Category   string
-	Categories []Category
-	Comments   []Comment
-	Date       time.Time
-  DateFormat string
+	ID          int
+	Title       string
+	AuthorID    int
+	Author      string
+	Message     string
+	Likes       int
+	Dislikes    int
+	Liked       bool
+	CategoryID  int
+	Category    string
+	Categories  []Category
+	Comments    []Comment
+	Attachments []Attachment
+	Date        time.Time
+	DateFormat  string
+	EditedAt    time.Time
+	DeletedAt   time.Time
+	// PublishAt is set while the post waits to be published.
+	PublishAt time.Time
+	// Rank is the score of the post in the listing it was read from, when
+	// that listing is ranked by one.
+	Rank float64
+}
+
+func (p *Post) Edited() bool {
+	return !p.EditedAt.IsZero()
+}
+
+func (p *Post) Deleted() bool {
+	return !p.DeletedAt.IsZero()
+}
+
+func (p *Post) Scheduled() bool {
+	return !p.PublishAt.IsZero()
+}
+
+// CanEdit reports whether u may edit or delete the post.
+func (p *Post) CanEdit(u *User) bool {
+	return u != nil && (u.ID == p.AuthorID || u.HasRole(RoleModerator))
+}
+
+// VisibleTo reports whether u may open the post; deleted posts are left to
+// moderators, and scheduled ones to them and the author.
+func (p *Post) VisibleTo(u *User) bool {
+	if u.HasRole(RoleModerator) {
+		return true
+	}
+	if p.Deleted() {
+		return false
+	}
+	return !p.Scheduled() || (u != nil && u.ID == p.AuthorID)
 }
 
 func (p *Post) SetDateFormat() {
-  p.DateFormat = p.Date.Format("02.01.2006 15:04")
+	p.DateFormat = p.Date.Format("02.01.2006 15:04")
 }
 
-type PostList []Post 
+func (p *Post) PublishAtFormat() string {
+	return p.PublishAt.Format("02.01.2006 15:04")
+}
+
+type PostList []Post
 
 func (p PostList) PrepToView() PostList {
-  for i := range p {
-    p[i].SetDateFormat()
-  }
-  return p
+	for i := range p {
+		p[i].SetDateFormat()
+	}
+	return p
 }
-
@@ -0,0 +1,42 @@
+package module
+
+// UserStats sums up the activity of a user for the profile page.
+type UserStats struct {
+	Posts            int
+	Comments         int
+	LikesReceived    int
+	DislikesReceived int
+}
+
+// ProfilePage is the view model of /user/{login}. Tab is "posts" or
+// "comments"; only the current page of that tab is loaded into User.
+type ProfilePage struct {
+	User          *User
+	Stats         UserStats
+	Tab           string
+	Page          int
+	HasNext       bool
+	Own           bool
+	Error         string
+	Authorization bool
+}
+
+func (p ProfilePage) PrevPage() int {
+	return p.Page - 1
+}
+
+func (p ProfilePage) NextPage() int {
+	return p.Page + 1
+}
+
+// Initial is shown instead of an avatar when the user has none.
+func (u *User) Initial() string {
+	for _, r := range u.Login {
+		return string(r)
+	}
+	return "?"
+}
+
+func (u *User) JoinedFormat() string {
+	return u.CreatedAt.Format("02.01.2006")
+}
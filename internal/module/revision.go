@@ -0,0 +1,41 @@
+package module
+
+import "time"
+
+// PostRevision is the content of a post as it was after one edit. Number
+// counts the revisions of a post from 1, the original.
+type PostRevision struct {
+	ID         int
+	PostID     int
+	Number     int
+	EditorID   int
+	Editor     string
+	Title      string
+	Message    string
+	Tags       []string
+	Date       time.Time
+	DateFormat string
+}
+
+const (
+	DiffSame   = "same"
+	DiffAdd    = "add"
+	DiffRemove = "remove"
+)
+
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// PostHistory is what the history page of a post shows: every revision and
+// the differences between From and To.
+type PostHistory struct {
+	Post      *Post
+	Revisions []PostRevision
+	From      *PostRevision
+	To        *PostRevision
+	Title     []DiffLine
+	Message   []DiffLine
+	Tags      []DiffLine
+}
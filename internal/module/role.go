@@ -0,0 +1,28 @@
+package module
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles from the least to the most privileged.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func ValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// HasRole reports whether the user has the role or a more privileged one.
+func (u *User) HasRole(role string) bool {
+	return u != nil && roleRank(role) >= 0 && roleRank(u.Role) >= roleRank(role)
+}
@@ -0,0 +1,55 @@
+package module
+
+import "time"
+
+// MatchStart and MatchEnd surround the words that matched in a search result.
+// Control characters can't be typed into posts, so they can't be faked.
+const (
+	MatchStart = "\x02"
+	MatchEnd   = "\x03"
+)
+
+// SearchForm is a search as the user typed it.
+type SearchForm struct {
+	Query  string
+	Author string
+	Tag    string
+	// From and To are dates, as a date input sends them.
+	From string
+	To   string
+	Page int
+}
+
+// SearchQuery is a search ready for the index: Match is an FTS5 query and the
+// rest narrows it down. To is exclusive.
+type SearchQuery struct {
+	Match  string
+	Author string
+	Tag    string
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+// SearchResult is a post, or a comment when CommentID is set, that matched a
+// search. Title and Snippet have the matches marked.
+type SearchResult struct {
+	PostID    int
+	CommentID int
+	Title     string
+	Snippet   string
+	Author    string
+	Date      time.Time
+	Rank      float64
+}
+
+func (r SearchResult) DateFormat() string {
+	return r.Date.Format("02.01.2006 15:04")
+}
+
+type SearchResults struct {
+	Results []SearchResult
+	Page    int
+	More    bool
+}
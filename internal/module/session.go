@@ -3,9 +3,32 @@ package module
 import "time"
 
 type Session struct {
-	ID       int
-	UserID   int
-	UUID     string
+	ID        int
+	UserID    int
+	UUID      string
+	UserAgent string
+	IP        string
 	CreatedAt time.Time
-	ExpiresAt  time.Time
+	ExpiresAt time.Time
+	LastSeen  time.Time
+	// Remember keeps the session for long and in a persistent cookie.
+	Remember bool
+	// Current marks the session of the request that lists them, Renewed one
+	// whose expiry was just moved forward.
+	Current bool
+	Renewed bool
+}
+
+// Client describes where a sign-in request came from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+func (s *Session) CreatedFormat() string {
+	return s.CreatedAt.Format("02.01.2006 15:04")
+}
+
+func (s *Session) LastSeenFormat() string {
+	return s.LastSeen.Format("02.01.2006 15:04")
 }
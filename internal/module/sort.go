@@ -0,0 +1,30 @@
+package module
+
+import "time"
+
+// Orders the post listings can be sorted in.
+const (
+	SortNew           = "new"
+	SortOld           = "old"
+	SortTop           = "top"
+	SortControversial = "controversial"
+	SortHot           = "hot"
+)
+
+var Sorts = []string{SortNew, SortOld, SortTop, SortControversial, SortHot}
+
+// TopWindows are how far back top posts are picked from; "all" has no limit.
+var TopWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"all":   0,
+}
+
+// PostSort is the order of a post listing. Window only applies to SortTop;
+// Since is the start of it, worked out by the service.
+type PostSort struct {
+	By     string
+	Window string
+	Since  time.Time
+}
@@ -0,0 +1,50 @@
+package module
+
+import "time"
+
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type EmailVerification struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	// Email is the new address of a pending email change, empty when the
+	// token confirms the address the account signed up with.
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// LoginChallenge is issued after a correct password when the account
+// has two-factor authentication; the session is created once a code is entered.
+type LoginChallenge struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	Attempts  int
+	Remember  bool
+	ExpiresAt time.Time
+}
+
+// LoginAttempt counts failed sign-ins for a Key such as "login:bob" or "ip:10.0.0.1".
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+func (a *LoginAttempt) Locked() bool {
+	return time.Now().Before(a.LockedUntil)
+}
+
+func (a *LoginAttempt) LockedUntilFormat() string {
+	return a.LockedUntil.Format("02.01.2006 15:04:05")
+}
@@ -0,0 +1,13 @@
+package module
+
+// TwoFactor is what the two-factor settings page shows.
+type TwoFactor struct {
+	Enabled bool
+	// Pending is set between generating a secret and confirming the first code.
+	Pending           bool
+	Required          bool
+	Secret            string
+	URI               string
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+}
@@ -1,11 +1,22 @@
 package module
 
+import "time"
+
 type User struct {
 	ID                int
 	Login             string
+	LoginKey          string
 	Password          string
 	EncryptedPassword string
 	Email             string
+	Verified          bool
+	Role              string
+	Bio               string
+	Avatar            string
+	CreatedAt         time.Time
+	TOTPSecret        string
+	TOTPEnabled       bool
+	TOTPLastStep      int64
 	Posts             []Post
 	Comments          []Comment
 	Authorization     bool
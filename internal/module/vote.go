@@ -0,0 +1,14 @@
+package module
+
+const (
+	VoteLike    = "like"
+	VoteDislike = "dislike"
+)
+
+// Vote is the tally of a post or comment together with the vote of the user
+// who asked for it: VoteLike, VoteDislike or empty.
+type Vote struct {
+	Likes    int    `json:"likes"`
+	Dislikes int    `json:"dislikes"`
+	State    string `json:"vote"`
+}
@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// DeletedAuthor replaces the name of a removed account on what it wrote.
+const DeletedAuthor = "[deleted]"
+
+type Account interface {
+	DeleteUser(userID int, removeContent bool) ([]module.Attachment, error)
+	GetExportPosts(userID int) ([]module.ExportPost, error)
+	GetExportComments(userID int) ([]module.ExportComment, error)
+	GetExportVotes(userID int) ([]module.ExportVote, error)
+	GetExportDrafts(userID int) ([]module.ExportDraft, error)
+	ChangePassword(userID int, encryptedPassword string) error
+	ChangeUsername(userID int, login, key string) error
+	GetUsersWithoutLoginKey() ([]module.User, error)
+	SetLoginKey(userID int, key string) error
+	CreateLoginKeyIndex() error
+}
+
+// ChangePassword stores the new password. Reset links sent before the change
+// stop working.
+func (r *AuthRepository) ChangePassword(userID int, encryptedPassword string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", encryptedPassword, userID); err != nil {
+		log.Println("error:authRepo:ChangePassword: update password: ", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		log.Println("error:authRepo:ChangePassword: delete reset tokens: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// ChangeUsername renames the user. Posts and comments keep a copy of their
+// author's name, so it is updated there too.
+func (r *AuthRepository) ChangeUsername(userID int, login, key string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var old string
+	if err := tx.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&old); err != nil {
+		return err
+	}
+	queries := []string{
+		"UPDATE users SET username = ?1 WHERE id = ?2",
+		"UPDATE posts SET author = ?1 WHERE author_id = ?2",
+		"UPDATE comments SET author = ?1 WHERE author_id = ?2",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, login, userID); err != nil {
+			if usernameTaken(err) {
+				return ErrUsernameTaken
+			}
+			log.Println("error:authRepo:ChangeUsername: ", err)
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE users SET login_key = ? WHERE id = ?", key, userID); err != nil {
+		if usernameTaken(err) {
+			return ErrUsernameTaken
+		}
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM login_attempts WHERE key = ?", "login:"+old); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUsersWithoutLoginKey returns the accounts created before usernames got
+// a login key.
+func (r *AuthRepository) GetUsersWithoutLoginKey() ([]module.User, error) {
+	rows, err := r.db.Query("SELECT id, username FROM users WHERE login_key = '' ORDER BY id")
+	if err != nil {
+		log.Println("error:authRepo:GetUsersWithoutLoginKey: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var users []module.User
+	for rows.Next() {
+		u := module.User{}
+		if err := rows.Scan(&u.ID, &u.Login); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (r *AuthRepository) SetLoginKey(userID int, key string) error {
+	_, err := r.db.Exec("UPDATE users SET login_key = ? WHERE id = ?", key, userID)
+	return err
+}
+
+// CreateLoginKeyIndex keeps two accounts from ever sharing a login key. It
+// can only be made once every account has a distinct one.
+func (r *AuthRepository) CreateLoginKeyIndex() error {
+	_, err := r.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS "users_login_key" ON "users" ("login_key") WHERE login_key != ''`)
+	return err
+}
+
+// DeleteUser removes an account and everything tied to it. The votes it cast
+// are taken back from the counters. Its posts and comments are removed when
+// removeContent is set, otherwise they stay, signed DeletedAuthor.
+func (r *AuthRepository) DeleteUser(userID int, removeContent bool) ([]module.Attachment, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var login string
+	if err := tx.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&login); err != nil {
+		return nil, err
+	}
+	queries := []string{
+		"UPDATE posts SET likes = likes - 1 WHERE id IN (SELECT post_id FROM likes WHERE user_id = ?1)",
+		"UPDATE posts SET dislikes = dislikes - 1 WHERE id IN (SELECT post_id FROM dislikes WHERE user_id = ?1)",
+		"UPDATE comments SET likes = likes - 1 WHERE id IN (SELECT comment_id FROM likes WHERE user_id = ?1)",
+		"UPDATE comments SET dislikes = dislikes - 1 WHERE id IN (SELECT comment_id FROM dislikes WHERE user_id = ?1)",
+		"DELETE FROM likes WHERE user_id = ?1",
+		"DELETE FROM dislikes WHERE user_id = ?1",
+	}
+	var attachments []module.Attachment
+	if removeContent {
+		attachments, err = queryAttachments(tx, "WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?)", userID)
+		if err != nil {
+			log.Println("error:authRepo:DeleteUser: ", err)
+			return nil, err
+		}
+		queries = append(queries,
+			// comments under the user's posts go with them
+			"DELETE FROM likes WHERE comment_id IN (SELECT id FROM comments WHERE author_id = ?1 OR post_id IN (SELECT id FROM posts WHERE author_id = ?1))",
+			"DELETE FROM dislikes WHERE comment_id IN (SELECT id FROM comments WHERE author_id = ?1 OR post_id IN (SELECT id FROM posts WHERE author_id = ?1))",
+			"DELETE FROM comments WHERE author_id = ?1 OR post_id IN (SELECT id FROM posts WHERE author_id = ?1)",
+			"DELETE FROM likes WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1)",
+			"DELETE FROM dislikes WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1)",
+			"DELETE FROM post_categories WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1)",
+			"DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1)",
+			"DELETE FROM attachments WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1)",
+			"DELETE FROM posts WHERE author_id = ?1",
+		)
+	} else {
+		// posts still waiting to be published are not kept, like drafts
+		attachments, err = queryAttachments(tx, "WHERE post_id IN (SELECT id FROM posts WHERE author_id = ? AND publish_at IS NOT NULL)", userID)
+		if err != nil {
+			log.Println("error:authRepo:DeleteUser: ", err)
+			return nil, err
+		}
+		queries = append(queries,
+			"DELETE FROM post_categories WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1 AND publish_at IS NOT NULL)",
+			"DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1 AND publish_at IS NOT NULL)",
+			"DELETE FROM attachments WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?1 AND publish_at IS NOT NULL)",
+			"DELETE FROM posts WHERE author_id = ?1 AND publish_at IS NOT NULL",
+			"UPDATE posts SET author_id = 0, author = '"+DeletedAuthor+"' WHERE author_id = ?1",
+			"UPDATE comments SET author_id = 0, author = '"+DeletedAuthor+"' WHERE author_id = ?1",
+		)
+	}
+	queries = append(queries,
+		// edits the user made to posts that stay
+		"UPDATE post_revisions SET editor_id = 0, editor = '"+DeletedAuthor+"' WHERE editor_id = ?1",
+		"DELETE FROM drafts WHERE user_id = ?1",
+		"DELETE FROM sessions WHERE user_id = ?1",
+		"DELETE FROM password_resets WHERE user_id = ?1",
+		"DELETE FROM email_verifications WHERE user_id = ?1",
+		"DELETE FROM recovery_codes WHERE user_id = ?1",
+		"DELETE FROM login_challenges WHERE user_id = ?1",
+		"DELETE FROM api_tokens WHERE user_id = ?1",
+		"DELETE FROM users WHERE id = ?1",
+	)
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			log.Println("error:authRepo:DeleteUser: ", err)
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM login_attempts WHERE key = ?", "login:"+login); err != nil {
+		return nil, err
+	}
+	return attachments, tx.Commit()
+}
+
+func (r *AuthRepository) GetExportPosts(userID int) ([]module.ExportPost, error) {
+	rows, err := r.db.Query(`SELECT p.id, p.title, p.message, p.likes, p.dislikes, p.date, p.edited_at, p.deleted_at, p.publish_at,
+		COALESCE((SELECT group_concat(c.name, char(31)) FROM post_categories pc JOIN categories c ON c.id = pc.category_id WHERE pc.post_id = p.id), ''),
+		COALESCE((SELECT group_concat(id) FROM attachments WHERE post_id = p.id), '')
+		FROM posts p WHERE p.author_id = ? ORDER BY p.date`, userID)
+	if err != nil {
+		log.Println("error:authRepo:GetExportPosts: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	posts := []module.ExportPost{}
+	for rows.Next() {
+		p := module.ExportPost{}
+		var date, edited, deleted, publishAt sql.NullTime
+		var tags, attachments string
+		if err := rows.Scan(&p.ID, &p.Title, &p.Message, &p.Likes, &p.Dislikes, &date, &edited, &deleted, &publishAt, &tags, &attachments); err != nil {
+			return nil, err
+		}
+		p.Date = date.Time
+		if edited.Valid {
+			p.EditedAt = &edited.Time
+		}
+		if deleted.Valid {
+			p.DeletedAt = &deleted.Time
+		}
+		if publishAt.Valid {
+			p.PublishAt = &publishAt.Time
+		}
+		p.Categories = []string{}
+		if tags != "" {
+			p.Categories = strings.Split(tags, "\x1f")
+		}
+		for _, id := range strings.Split(attachments, ",") {
+			if n, err := strconv.Atoi(id); err == nil {
+				a := module.Attachment{ID: n}
+				p.Attachments = append(p.Attachments, a.URL())
+			}
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (r *AuthRepository) GetExportComments(userID int) ([]module.ExportComment, error) {
+	rows, err := r.db.Query("SELECT id, post_id, message, likes, dislikes, date FROM comments WHERE author_id = ? ORDER BY date", userID)
+	if err != nil {
+		log.Println("error:authRepo:GetExportComments: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []module.ExportComment{}
+	for rows.Next() {
+		c := module.ExportComment{}
+		var date sql.NullTime
+		if err := rows.Scan(&c.ID, &c.PostID, &c.Message, &c.Likes, &c.Dislikes, &date); err != nil {
+			return nil, err
+		}
+		c.Date = date.Time
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (r *AuthRepository) GetExportVotes(userID int) ([]module.ExportVote, error) {
+	rows, err := r.db.Query(`SELECT 'like', COALESCE(post_id, 0), COALESCE(comment_id, 0) FROM likes WHERE user_id = ?1
+		UNION ALL
+		SELECT 'dislike', COALESCE(post_id, 0), COALESCE(comment_id, 0) FROM dislikes WHERE user_id = ?1`, userID)
+	if err != nil {
+		log.Println("error:authRepo:GetExportVotes: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	votes := []module.ExportVote{}
+	for rows.Next() {
+		v := module.ExportVote{}
+		if err := rows.Scan(&v.Vote, &v.PostID, &v.CommentID); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+func (r *AuthRepository) GetExportDrafts(userID int) ([]module.ExportDraft, error) {
+	rows, err := r.db.Query("SELECT title, message, tags, created_at, updated_at FROM drafts WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		log.Println("error:authRepo:GetExportDrafts: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	drafts := []module.ExportDraft{}
+	for rows.Next() {
+		d := module.ExportDraft{}
+		if err := rows.Scan(&d.Title, &d.Message, &d.Tags, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
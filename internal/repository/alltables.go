@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -10,8 +11,17 @@ import (
 const userTable = `CREATE TABLE IF NOT EXISTS "users" (
 	"id"				INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
 	"username"			TEXT UNIQUE NOT NULL,
+	"login_key"			TEXT NOT NULL DEFAULT '',
 	"password"			TEXT NOT NULL,
-	"email"				TEXT UNIQUE NOT NULL
+	"email"				TEXT UNIQUE NOT NULL,
+	"verified"			INTEGER NOT NULL DEFAULT 0,
+	"created_at"		DATETIME DEFAULT NULL,
+	"totp_secret"		TEXT NOT NULL DEFAULT '',
+	"totp_enabled"		INTEGER NOT NULL DEFAULT 0,
+	"totp_last_step"	INTEGER NOT NULL DEFAULT 0,
+	"role"				TEXT NOT NULL DEFAULT 'user',
+	"bio"				TEXT NOT NULL DEFAULT '',
+	"avatar"			TEXT NOT NULL DEFAULT ''
 );`
 
 const postTable = `CREATE TABLE IF NOT EXISTS "posts" (
@@ -24,6 +34,10 @@ const postTable = `CREATE TABLE IF NOT EXISTS "posts" (
 	"dislikes" 	INTEGER DEFAULT 0,
 	"category_id"	INTEGER NOT NULL,
   date DATETIME DEFAULT NULL,
+	"ts"			INTEGER NOT NULL DEFAULT 0,
+	"edited_at"	DATETIME DEFAULT NULL,
+	"deleted_at"	DATETIME DEFAULT NULL,
+	"publish_at"	DATETIME DEFAULT NULL,
 	FOREIGN KEY(author_id) REFERENCES "users"(id), 
 	FOREIGN KEY(category_id) REFERENCES "categories"(id) 
 );`
@@ -37,6 +51,7 @@ const commentTable = `CREATE TABLE IF NOT EXISTS "comments" (
 	"post_id"		INTEGER NOT NULL,
 	"message"		TEXT NOT NULL,
 	"date"		DATETIME DEFAULT NULL,
+	"ts"		INTEGER NOT NULL DEFAULT 0,
 	FOREIGN KEY(author_id) REFERENCES "users"(id),
 	FOREIGN KEY(post_id) REFERENCES "posts"(id)
 );`
@@ -45,17 +60,31 @@ const sessionTable = `CREATE TABLE IF NOT EXISTS "sessions" (
 	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
 	"user_id"	INTEGER NOT NULL,
 	"uuid"		TEXT NOT NULL,
+	"user_agent"	TEXT NOT NULL DEFAULT '',
+	"ip"		TEXT NOT NULL DEFAULT '',
 	"created_at"	DATETIME DEFAULT NULL,
 	"expires_at"	DATETIME DEFAULT NULL,
+	"last_seen"	DATETIME DEFAULT NULL,
+	"remember"	INTEGER NOT NULL DEFAULT 0,
 	FOREIGN KEY(user_id) REFERENCES "users"(id) ON DELETE CASCADE
 );`
 
 const categoryTable = `CREATE TABLE IF NOT EXISTS "categories" (
-	"id"	INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
-	"postid" INTEGER,
-	"tag"	TEXT NOT NULL,
-	"theme" TEXT NOT NULL DEFAULT ' ',
-	"description"	TEXT NOT NULL DEFAULT ' '
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"name"		TEXT UNIQUE NOT NULL COLLATE NOCASE,
+	"slug"		TEXT UNIQUE NOT NULL,
+	"description"	TEXT NOT NULL DEFAULT '',
+	"color"		TEXT NOT NULL DEFAULT '#50FA7B',
+	"position"	INTEGER NOT NULL DEFAULT 0,
+	"created_at"	DATETIME DEFAULT NULL
+);`
+
+const postCategoryTable = `CREATE TABLE IF NOT EXISTS "post_categories" (
+	"post_id"	INTEGER NOT NULL,
+	"category_id"	INTEGER NOT NULL,
+	PRIMARY KEY("post_id", "category_id"),
+	FOREIGN KEY(post_id) REFERENCES "posts"(id),
+	FOREIGN KEY(category_id) REFERENCES "categories"(id)
 );`
 
 const likesTable = `CREATE TABLE IF NOT EXISTS "likes" (
@@ -70,12 +99,156 @@ const dislikesTable = `CREATE TABLE IF NOT EXISTS "dislikes" (
 	"comment_id" INTEGER DEFAULT NULL
 );`
 
-var tables = []string{userTable, postTable, commentTable, sessionTable, categoryTable, likesTable, dislikesTable}
+const passwordResetTable = `CREATE TABLE IF NOT EXISTS "password_resets" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"user_id"	INTEGER NOT NULL,
+	"token_hash"	TEXT UNIQUE NOT NULL,
+	"created_at"	DATETIME DEFAULT NULL,
+	"expires_at"	DATETIME DEFAULT NULL,
+	"used_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES "users"(id) ON DELETE CASCADE
+);`
+
+const emailVerificationTable = `CREATE TABLE IF NOT EXISTS "email_verifications" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"user_id"	INTEGER NOT NULL,
+	"token_hash"	TEXT UNIQUE NOT NULL,
+	"email"			TEXT NOT NULL DEFAULT '',
+	"created_at"	DATETIME DEFAULT NULL,
+	"expires_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES "users"(id) ON DELETE CASCADE
+);`
+
+const recoveryCodeTable = `CREATE TABLE IF NOT EXISTS "recovery_codes" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"user_id"	INTEGER NOT NULL,
+	"code_hash"	TEXT NOT NULL,
+	"used_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES "users"(id) ON DELETE CASCADE
+);`
+
+const loginChallengeTable = `CREATE TABLE IF NOT EXISTS "login_challenges" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"user_id"	INTEGER NOT NULL,
+	"token_hash"	TEXT UNIQUE NOT NULL,
+	"attempts"	INTEGER NOT NULL DEFAULT 0,
+	"remember"	INTEGER NOT NULL DEFAULT 0,
+	"expires_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES "users"(id) ON DELETE CASCADE
+);`
+
+const loginAttemptTable = `CREATE TABLE IF NOT EXISTS "login_attempts" (
+	"key"		TEXT PRIMARY KEY NOT NULL,
+	"failures"	INTEGER NOT NULL DEFAULT 0,
+	"last_failure"	DATETIME DEFAULT NULL,
+	"locked_until"	DATETIME DEFAULT NULL
+);`
+
+const apiTokenTable = `CREATE TABLE IF NOT EXISTS "api_tokens" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"user_id"	INTEGER NOT NULL,
+	"name"		TEXT NOT NULL,
+	"token_hash"	TEXT UNIQUE NOT NULL,
+	"scopes"	TEXT NOT NULL DEFAULT '',
+	"created_at"	DATETIME DEFAULT NULL,
+	"expires_at"	DATETIME DEFAULT NULL,
+	"last_used"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES "users"(id)
+);`
+
+const postRevisionTable = `CREATE TABLE IF NOT EXISTS "post_revisions" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"post_id"	INTEGER NOT NULL,
+	"editor_id"	INTEGER NOT NULL,
+	"editor"	TEXT NOT NULL,
+	"title"		TEXT NOT NULL,
+	"message"	TEXT NOT NULL,
+	"tags"		TEXT NOT NULL DEFAULT '',
+	"created_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(post_id) REFERENCES "posts"(id)
+);`
+
+const attachmentTable = `CREATE TABLE IF NOT EXISTS "attachments" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"post_id"	INTEGER NOT NULL,
+	"blob_key"	TEXT UNIQUE NOT NULL,
+	"content_type"	TEXT NOT NULL,
+	"size"		INTEGER NOT NULL,
+	"width"		INTEGER NOT NULL,
+	"height"	INTEGER NOT NULL,
+	"thumb_key"	TEXT UNIQUE NOT NULL,
+	"thumb_type"	TEXT NOT NULL,
+	"created_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(post_id) REFERENCES "posts"(id)
+);`
+
+const draftTable = `CREATE TABLE IF NOT EXISTS "drafts" (
+	"id"		INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+	"user_id"	INTEGER NOT NULL,
+	"title"		TEXT NOT NULL DEFAULT '',
+	"message"	TEXT NOT NULL DEFAULT '',
+	"tags"		TEXT NOT NULL DEFAULT '',
+	"created_at"	DATETIME DEFAULT NULL,
+	"updated_at"	DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES "users"(id)
+);`
+
+var tables = []string{
+	userTable, postTable, commentTable, sessionTable, categoryTable, postCategoryTable, likesTable, dislikesTable,
+	passwordResetTable, emailVerificationTable, recoveryCodeTable, loginChallengeTable, loginAttemptTable,
+	apiTokenTable, postRevisionTable, attachmentTable, draftTable,
+}
+
+// column is added to databases created before it appeared in the table definition.
+// backfill runs once, right after the column is added.
+type column struct {
+	table      string
+	name       string
+	definition string
+	backfill   string
+}
+
+var columns = []column{
+	{"users", "verified", "INTEGER NOT NULL DEFAULT 0", "UPDATE users SET verified = 1"},
+	{"users", "created_at", "DATETIME DEFAULT NULL", "UPDATE users SET created_at = CURRENT_TIMESTAMP"},
+	{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''", ""},
+	{"users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'", ""},
+	{"users", "bio", "TEXT NOT NULL DEFAULT ''", ""},
+	{"users", "avatar", "TEXT NOT NULL DEFAULT ''", ""},
+	{"sessions", "user_agent", "TEXT NOT NULL DEFAULT ''", ""},
+	{"sessions", "ip", "TEXT NOT NULL DEFAULT ''", ""},
+	{"sessions", "last_seen", "DATETIME DEFAULT NULL", "UPDATE sessions SET last_seen = created_at"},
+	{"email_verifications", "email", "TEXT NOT NULL DEFAULT ''", ""},
+	{"sessions", "remember", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"login_challenges", "remember", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"users", "login_key", "TEXT NOT NULL DEFAULT ''", ""},
+	{"posts", "edited_at", "DATETIME DEFAULT NULL", ""},
+	{"posts", "deleted_at", "DATETIME DEFAULT NULL", ""},
+	{"posts", "publish_at", "DATETIME DEFAULT NULL", ""},
+	{"posts", "ts", "INTEGER NOT NULL DEFAULT 0", "UPDATE posts SET ts = COALESCE(strftime('%s', date), 0)"},
+	{"comments", "ts", "INTEGER NOT NULL DEFAULT 0", "UPDATE comments SET ts = COALESCE(strftime('%s', date), 0)"},
+}
+
+// indexes are made after the columns, which they may use. Listings are
+// ordered by ts, the date as unix time, rather than by julianday(date): SQLite
+// versions round fractional seconds differently, and an index on the
+// expression written by one of them reads as corrupt to another.
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS "posts_ts" ON "posts" ("ts", "id")`,
+	`CREATE INDEX IF NOT EXISTS "comments_post_ts" ON "comments" ("post_id", "ts", "id")`,
+	`CREATE INDEX IF NOT EXISTS "comments_post_id" ON "comments" ("post_id")`,
+	`CREATE INDEX IF NOT EXISTS "comments_author_id" ON "comments" ("author_id")`,
+	`CREATE INDEX IF NOT EXISTS "post_categories_category_id" ON "post_categories" ("category_id", "post_id")`,
+	`CREATE INDEX IF NOT EXISTS "likes_user_id" ON "likes" ("user_id", "post_id")`,
+	`CREATE INDEX IF NOT EXISTS "dislikes_user_id" ON "dislikes" ("user_id", "post_id")`,
+}
 
 func Init() (*sql.DB, error) {
 	var err error
 
-	db, err := sql.Open("sqlite3", "Forum.db")
+	db, err := sql.Open(driverName, "Forum.db")
 	if err != nil {
 		log.Println("❌ error | can't create DB")
 		return nil, err
@@ -90,5 +263,53 @@ func CreateDatabase(db *sql.DB) error {
 			return err
 		}
 	}
+	if err := migrateCategories(db); err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c); err != nil {
+			return err
+		}
+	}
+	for _, index := range indexes {
+		if _, err := db.Exec(index); err != nil {
+			return err
+		}
+	}
+	return createSearchIndex(db)
+}
+
+func addColumn(db *sql.DB, c column) error {
+	exists, err := hasColumn(db, c.table, c.name)
+	if err != nil || exists {
+		return err
+	}
+	log.Printf("repository:addColumn: %s.%s\n", c.table, c.name)
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s", c.table, c.name, c.definition)); err != nil {
+		return err
+	}
+	if c.backfill != "" {
+		if _, err := db.Exec(c.backfill); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func hasColumn(db *sql.DB, table, name string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return false, err
+		}
+		if col == name {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
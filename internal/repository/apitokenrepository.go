@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+type APITokens interface {
+	CreateAPIToken(t *module.APIToken) error
+	GetAPITokenByHash(tokenHash string) (*module.APIToken, error)
+	GetAPITokensByUserID(userID int) ([]module.APIToken, error)
+	TouchAPIToken(id int, lastUsed time.Time) error
+	DeleteAPIToken(id, userID int) error
+}
+
+func (r *AuthRepository) CreateAPIToken(t *module.APIToken) error {
+	var expiresAt sql.NullTime
+	if !t.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: t.ExpiresAt, Valid: true}
+	}
+	res, err := r.db.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		t.UserID, t.Name, t.TokenHash, strings.Join(t.Scopes, ","), t.CreatedAt, expiresAt,
+	)
+	if err != nil {
+		log.Println("error:authRepo:CreateAPIToken: ", err)
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = int(id)
+	return nil
+}
+
+const apiTokenColumns = "id, user_id, name, token_hash, scopes, created_at, expires_at, last_used"
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*module.APIToken, error) {
+	t := &module.APIToken{}
+	var scopes string
+	var createdAt, expiresAt, lastUsed sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &createdAt, &expiresAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	t.CreatedAt, t.ExpiresAt, t.LastUsed = createdAt.Time, expiresAt.Time, lastUsed.Time
+	return t, nil
+}
+
+func (r *AuthRepository) GetAPITokenByHash(tokenHash string) (*module.APIToken, error) {
+	return scanAPIToken(r.db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash))
+}
+
+func (r *AuthRepository) GetAPITokensByUserID(userID int) ([]module.APIToken, error) {
+	rows, err := r.db.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		log.Println("error:authRepo:GetAPITokensByUserID: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []module.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *AuthRepository) TouchAPIToken(id int, lastUsed time.Time) error {
+	if _, err := r.db.Exec("UPDATE api_tokens SET last_used = ? WHERE id = ?", lastUsed, id); err != nil {
+		log.Println("error:authRepo:TouchAPIToken: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteAPIToken returns sql.ErrNoRows when the user has no such token.
+func (r *AuthRepository) DeleteAPIToken(id, userID int) error {
+	res, err := r.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		log.Println("error:authRepo:DeleteAPIToken: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
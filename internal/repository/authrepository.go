@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/ive663/forum/internal/module"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Auth interface {
@@ -15,12 +17,33 @@ type Auth interface {
 	Delete(uuid string) error
 	CreateNewUser(*module.User) error
 	FindByLogin(login string) (*module.User, error)
+	FindByLoginKey(key string) (*module.User, error)
 	GetUserByID(id int) (*module.User, error)
 	DeleteExpiredSession() error
-	UpdateSession(s *module.Session) error
-	IsSessionExists(userID int) (bool, error)
+	GetSessionByUUID(uuid string) (*module.Session, error)
+	GetSessionsByUserID(userID int) ([]module.Session, error)
+	TouchSession(s *module.Session) error
+	RotateSession(uuid, newUUID string) error
+	DeleteSessionByID(id, userID int) error
+	DeleteSessionsByUserID(userID int, keep string) error
+	TwoFactor
+	LoginAttempts
+	Roles
+	APITokens
+	Account
+	FindByEmail(email string) (*module.User, error)
+	CreatePasswordReset(p *module.PasswordReset) error
+	GetPasswordReset(tokenHash string) (*module.PasswordReset, error)
+	ResetPassword(p *module.PasswordReset, encryptedPassword string) error
+	DeleteExpiredPasswordResets() error
+	CreateEmailVerification(v *module.EmailVerification) error
+	GetEmailVerification(tokenHash string) (*module.EmailVerification, error)
+	VerifyUser(v *module.EmailVerification) error
+	DeleteUnverifiedUsers(createdBefore time.Time) ([]int, error)
 }
 
+var ErrResetTokenUsed = errors.New("reset token already used")
+
 type AuthRepository struct {
 	db *sql.DB
 }
@@ -31,35 +54,19 @@ func newAuthRepository(db *sql.DB) *AuthRepository {
 	}
 }
 
-func (r *AuthRepository) IsSessionExists(userID int) (bool, error) {
-	user_id := "0"
-	err := r.db.QueryRow("SELECT uuid FROM sessions WHERE user_id = $1", userID).Scan(&user_id)
-	if err != nil {
-		log.Println("repo:IsSessionExist: false")
-		return false, nil
-	}
-	log.Println("repo:IsSessionExist: true")
-	return user_id != "0", nil
-}
-
 func (r *AuthRepository) CreateNewSession(s *module.Session) error {
-	query := "INSERT INTO sessions (user_id, uuid, created_at, expires_at) VALUES (?, ?, ?, ?)"
-	_, err := r.db.Exec(query, s.UserID, s.UUID, s.CreatedAt, s.ExpiresAt)
+	query := "INSERT INTO sessions (user_id, uuid, user_agent, ip, created_at, expires_at, last_seen, remember) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	res, err := r.db.Exec(query, s.UserID, s.UUID, s.UserAgent, s.IP, s.CreatedAt, s.ExpiresAt, s.LastSeen, s.Remember)
 	log.Println("repo:auth: session ID creatted")
 	if err != nil {
 		log.Println("err:repo:auth: CreateNewSession")
 		return err
 	}
-	return nil
-}
-
-func (r *AuthRepository) UpdateSession(s *module.Session) error {
-	query := "UPDATE sessions SET uuid = ?, created_at = ?, expires_at = ? WHERE user_id = ?"
-	_, err := r.db.Exec(query, s.UUID, s.CreatedAt, s.ExpiresAt, s.UserID)
-	log.Println("authrepo: updateSession")
+	id, err := res.LastInsertId()
 	if err != nil {
-		log.Println("error:authRepo: UpdateSession")
+		return err
 	}
+	s.ID = int(id)
 	return nil
 }
 
@@ -79,6 +86,87 @@ func (r *AuthRepository) GetUserIdByUUID(UUID string) (int, error) {
 	return s.UserID, nil
 }
 
+func (r *AuthRepository) GetSessionByUUID(uuid string) (*module.Session, error) {
+	s := &module.Session{}
+	var lastSeen sql.NullTime
+	err := r.db.QueryRow(
+		"SELECT id, user_id, uuid, user_agent, ip, created_at, expires_at, last_seen, remember FROM sessions WHERE uuid = ?",
+		uuid,
+	).Scan(&s.ID, &s.UserID, &s.UUID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.ExpiresAt, &lastSeen, &s.Remember)
+	if err != nil {
+		return nil, err
+	}
+	s.LastSeen = lastSeen.Time
+	return s, nil
+}
+
+func (r *AuthRepository) GetSessionsByUserID(userID int) ([]module.Session, error) {
+	rows, err := r.db.Query(
+		"SELECT id, user_id, uuid, user_agent, ip, created_at, expires_at, last_seen, remember FROM sessions WHERE user_id = ? ORDER BY last_seen DESC",
+		userID,
+	)
+	if err != nil {
+		log.Println("error:authRepo:GetSessionsByUserID: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []module.Session
+	for rows.Next() {
+		s := module.Session{}
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&s.ID, &s.UserID, &s.UUID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.ExpiresAt, &lastSeen, &s.Remember); err != nil {
+			return nil, err
+		}
+		s.LastSeen = lastSeen.Time
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession stores the last-seen time and the expiry of the session.
+func (r *AuthRepository) TouchSession(s *module.Session) error {
+	if _, err := r.db.Exec("UPDATE sessions SET last_seen = ?, expires_at = ? WHERE id = ?", s.LastSeen, s.ExpiresAt, s.ID); err != nil {
+		log.Println("error:authRepo:TouchSession: ", err)
+		return err
+	}
+	return nil
+}
+
+// RotateSession gives the session a new identifier, so the old one stops working.
+func (r *AuthRepository) RotateSession(uuid, newUUID string) error {
+	res, err := r.db.Exec("UPDATE sessions SET uuid = ? WHERE uuid = ?", newUUID, uuid)
+	if err != nil {
+		log.Println("error:authRepo:RotateSession: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteSessionByID removes a session only if it belongs to userID.
+func (r *AuthRepository) DeleteSessionByID(id, userID int) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		log.Println("error:authRepo:DeleteSessionByID: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteSessionsByUserID removes every session of the user except keep.
+func (r *AuthRepository) DeleteSessionsByUserID(userID int, keep string) error {
+	if _, err := r.db.Exec("DELETE FROM sessions WHERE user_id = ? AND uuid != ?", userID, keep); err != nil {
+		log.Println("error:authRepo:DeleteSessionsByUserID: ", err)
+		return err
+	}
+	return nil
+}
+
 func (r *AuthRepository) Delete(uuid string) error {
 	if _, err := r.db.Exec("DELETE FROM sessions WHERE uuid = ?", uuid); err != nil {
 		log.Print(err)
@@ -88,36 +176,68 @@ func (r *AuthRepository) Delete(uuid string) error {
 }
 
 func (r *AuthRepository) CreateNewUser(u *module.User) error {
-	log.Println(u.Login, u.EncryptedPassword, u.Email)
-	query := "INSERT INTO users (username, password, email) VALUES (?, ?, ?)"
-	if _, err := r.db.Exec(query, u.Login, u.EncryptedPassword, u.Email); err != nil {
+	query := "INSERT INTO users (username, login_key, password, email, verified, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := r.db.Exec(query, u.Login, u.LoginKey, u.EncryptedPassword, u.Email, u.Verified, u.CreatedAt); err != nil {
+		if usernameTaken(err) {
+			return ErrUsernameTaken
+		}
 		log.Printf("error:authRepo:CreatingNewUser %v\n", err)
 		return err
 	}
 	return nil
 }
 
+// ErrUsernameTaken is returned when another account got the username, or one
+// with the same login key, first.
+var ErrUsernameTaken = errors.New("username taken")
+
+func isUnique(err error) bool {
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func usernameTaken(err error) bool {
+	return isUnique(err) && (strings.Contains(err.Error(), "users.username") || strings.Contains(err.Error(), "users.login_key"))
+}
+
+// FindByLogin returns the account named login, or sql.ErrNoRows.
 func (r *AuthRepository) FindByLogin(login string) (*module.User, error) {
 	if login == "" {
-		return nil, errors.New("error:authRepo:findByLogin empty str")
+		return nil, sql.ErrNoRows
 	}
 	u := &module.User{}
 	err := r.db.QueryRow(
-		"SELECT id, username, password FROM users WHERE username = ?",
+		"SELECT id, username, password, totp_enabled FROM users WHERE username = ?",
 		login,
-	).Scan(&u.ID, &u.Login, &u.EncryptedPassword)
+	).Scan(&u.ID, &u.Login, &u.EncryptedPassword, &u.TOTPEnabled)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("error:authRepo:findByLogin: Record not found")
+		return nil, err
 	}
 	if err != nil {
-		return nil, errors.New("error:authRepo:findByLogin: Record not found")
+		log.Println("error:authRepo:findByLogin: DB error")
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindByLoginKey returns the account whose name looks like one with key, or
+// sql.ErrNoRows.
+func (r *AuthRepository) FindByLoginKey(key string) (*module.User, error) {
+	u := &module.User{}
+	err := r.db.QueryRow("SELECT id, username, login_key FROM users WHERE login_key = ? LIMIT 1", key).Scan(&u.ID, &u.Login, &u.LoginKey)
+	if err != nil {
+		return nil, err
 	}
 	return u, nil
 }
 
 func (r *AuthRepository) GetUserByID(id int) (*module.User, error) {
 	u := &module.User{}
-	err := r.db.QueryRow("SELECT id, username FROM users WHERE id = ?", id).Scan(&u.ID, &u.Login)
+	var createdAt sql.NullTime
+	err := r.db.QueryRow(
+		"SELECT id, username, password, email, verified, role, bio, avatar, created_at, totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?",
+		id,
+	).Scan(&u.ID, &u.Login, &u.EncryptedPassword, &u.Email, &u.Verified, &u.Role, &u.Bio, &u.Avatar, &createdAt, &u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep)
 	if err == sql.ErrNoRows {
 		log.Println("error:authRepo:GetUserByID: Record not found")
 		return nil, err
@@ -126,6 +246,7 @@ func (r *AuthRepository) GetUserByID(id int) (*module.User, error) {
 		log.Println("error:authRepo:GetUserByID: DB error")
 		return nil, err
 	}
+	u.CreatedAt = createdAt.Time
 	return u, nil
 }
 
@@ -136,3 +257,178 @@ func (r *AuthRepository) DeleteExpiredSession() error {
 	}
 	return nil
 }
+
+func (r *AuthRepository) FindByEmail(email string) (*module.User, error) {
+	u := &module.User{}
+	err := r.db.QueryRow(
+		"SELECT id, username, password, email FROM users WHERE email = ?",
+		email,
+	).Scan(&u.ID, &u.Login, &u.EncryptedPassword, &u.Email)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// CreatePasswordReset stores a new reset token and drops any unused ones the user still had.
+func (r *AuthRepository) CreatePasswordReset(p *module.PasswordReset) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE user_id = ? AND used_at IS NULL", p.UserID); err != nil {
+		log.Println("error:authRepo:CreatePasswordReset: delete old tokens: ", err)
+		return err
+	}
+	query := "INSERT INTO password_resets (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)"
+	if _, err := tx.Exec(query, p.UserID, p.TokenHash, p.CreatedAt, p.ExpiresAt); err != nil {
+		log.Println("error:authRepo:CreatePasswordReset: insert: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *AuthRepository) GetPasswordReset(tokenHash string) (*module.PasswordReset, error) {
+	p := &module.PasswordReset{}
+	var usedAt sql.NullTime
+	err := r.db.QueryRow(
+		"SELECT id, user_id, token_hash, created_at, expires_at, used_at FROM password_resets WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&p.ID, &p.UserID, &p.TokenHash, &p.CreatedAt, &p.ExpiresAt, &usedAt)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		p.UsedAt = &usedAt.Time
+	}
+	return p, nil
+}
+
+// ResetPassword consumes the reset token, stores the new password hash and
+// drops every session of the user in a single transaction.
+func (r *AuthRepository) ResetPassword(p *module.PasswordReset, encryptedPassword string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now(), p.ID)
+	if err != nil {
+		log.Println("error:authRepo:ResetPassword: mark used: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrResetTokenUsed
+	}
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", encryptedPassword, p.UserID); err != nil {
+		log.Println("error:authRepo:ResetPassword: update password: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *AuthRepository) DeleteExpiredPasswordResets() error {
+	if _, err := r.db.Exec("DELETE FROM password_resets WHERE expires_at < ?", time.Now()); err != nil {
+		log.Print("error:authRepo:DeleteExpiredPasswordResets")
+		return err
+	}
+	return nil
+}
+
+// CreateEmailVerification replaces any previous verification token of the user.
+func (r *AuthRepository) CreateEmailVerification(v *module.EmailVerification) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM email_verifications WHERE user_id = ?", v.UserID); err != nil {
+		log.Println("error:authRepo:CreateEmailVerification: delete old tokens: ", err)
+		return err
+	}
+	query := "INSERT INTO email_verifications (user_id, token_hash, email, created_at, expires_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(query, v.UserID, v.TokenHash, v.Email, v.CreatedAt, v.ExpiresAt); err != nil {
+		log.Println("error:authRepo:CreateEmailVerification: insert: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *AuthRepository) GetEmailVerification(tokenHash string) (*module.EmailVerification, error) {
+	v := &module.EmailVerification{}
+	err := r.db.QueryRow(
+		"SELECT id, user_id, token_hash, email, created_at, expires_at FROM email_verifications WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&v.ID, &v.UserID, &v.TokenHash, &v.Email, &v.CreatedAt, &v.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// VerifyUser marks the account as confirmed. For an email change it also
+// switches the account to the new address.
+func (r *AuthRepository) VerifyUser(v *module.EmailVerification) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if v.Email != "" {
+		if _, err := tx.Exec("UPDATE users SET email = ? WHERE id = ?", v.Email, v.UserID); err != nil {
+			log.Println("error:authRepo:VerifyUser: update email: ", err)
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE users SET verified = 1 WHERE id = ?", v.UserID); err != nil {
+		log.Println("error:authRepo:VerifyUser: update user: ", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM email_verifications WHERE user_id = ?", v.UserID); err != nil {
+		log.Println("error:authRepo:VerifyUser: delete tokens: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUnverifiedUsers removes accounts that never confirmed their email and returns their ids.
+// Such accounts can't post, comment or vote, so only their sessions and tokens are left behind.
+func (r *AuthRepository) DeleteUnverifiedUsers(createdBefore time.Time) ([]int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id FROM users WHERE verified = 0 AND created_at < ?", createdBefore)
+	if err != nil {
+		log.Println("error:authRepo:DeleteUnverifiedUsers: select ", err)
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		for _, table := range []string{"sessions", "email_verifications", "password_resets", "recovery_codes", "login_challenges", "api_tokens"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+				log.Println("error:authRepo:DeleteUnverifiedUsers: ", table, err)
+				return nil, err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+			log.Println("error:authRepo:DeleteUnverifiedUsers: users ", err)
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// ErrCategoryExists is returned when another category has the name or slug.
+var ErrCategoryExists = errors.New("category exists")
+
+type Categories interface {
+	GetCatalogue() ([]module.Category, error)
+	GetCategories() ([]module.Category, error)
+	GetCategoryBySlug(slug string) (*module.Category, error)
+	CreateCategory(c *module.Category) error
+	UpdateCategory(c *module.Category) error
+	DeleteCategory(id int) error
+}
+
+type CategoryRepository struct {
+	db *sql.DB
+}
+
+func newCategoryRepository(db *sql.DB) *CategoryRepository {
+	return &CategoryRepository{
+		db: db,
+	}
+}
+
+// categorySelect reads categories with the number of their published posts
+// and the unix time of the latest post or comment among them, 0 if none.
+const categorySelect = `SELECT c.id, c.name, c.slug, c.description, c.color, c.position, count(p.id),
+	COALESCE(max(max(p.ts, COALESCE((SELECT max(ts) FROM comments WHERE post_id = p.id), 0))), 0)
+	FROM categories c
+	LEFT JOIN post_categories pc ON pc.category_id = c.id
+	LEFT JOIN posts p ON p.id = pc.post_id AND p.deleted_at IS NULL AND p.publish_at IS NULL`
+
+const categoryOrder = " ORDER BY c.position, c.name COLLATE NOCASE"
+
+func scanCategory(row interface{ Scan(...interface{}) error }) (module.Category, error) {
+	var c module.Category
+	var latest int64
+	if err := row.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.Color, &c.Position, &c.PostCount, &latest); err != nil {
+		return c, err
+	}
+	if latest > 0 {
+		c.LatestActivity = time.Unix(latest, 0)
+	}
+	return c, nil
+}
+
+// GetCatalogue returns the categories alone, without their posts, in the
+// order administrators gave them.
+func (r *CategoryRepository) GetCatalogue() ([]module.Category, error) {
+	rows, err := r.db.Query("SELECT id, name, slug, description, color, position FROM categories c" + categoryOrder)
+	if err != nil {
+		log.Println("error:categoryRepo:GetCatalogue: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var categories []module.Category
+	for rows.Next() {
+		var c module.Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.Color, &c.Position); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// GetCategories returns the catalogue in the order administrators gave it,
+// with the posts counted.
+func (r *CategoryRepository) GetCategories() ([]module.Category, error) {
+	rows, err := r.db.Query(categorySelect + " GROUP BY c.id" + categoryOrder)
+	if err != nil {
+		log.Println("error:categoryRepo:GetCategories: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var categories []module.Category
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func (r *CategoryRepository) GetCategoryBySlug(slug string) (*module.Category, error) {
+	c, err := scanCategory(r.db.QueryRow(categorySelect+" WHERE c.slug = ? GROUP BY c.id", slug))
+	if err == sql.ErrNoRows {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		log.Println("error:categoryRepo:GetCategoryBySlug: ", err)
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *CategoryRepository) CreateCategory(c *module.Category) error {
+	query := "INSERT INTO categories (name, slug, description, color, position, created_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"
+	err := r.db.QueryRow(query, c.Name, c.Slug, c.Description, c.Color, c.Position, time.Now()).Scan(&c.ID)
+	if isUnique(err) {
+		return ErrCategoryExists
+	}
+	return err
+}
+
+func (r *CategoryRepository) UpdateCategory(c *module.Category) error {
+	res, err := r.db.Exec("UPDATE categories SET name = ?, slug = ?, description = ?, color = ?, position = ? WHERE id = ?",
+		c.Name, c.Slug, c.Description, c.Color, c.Position, c.ID)
+	if isUnique(err) {
+		return ErrCategoryExists
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteCategory removes a category from the catalogue and from the posts
+// that had it.
+func (r *CategoryRepository) DeleteCategory(id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE category_id = ?", id); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM categories WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+	return tx.Commit()
+}
+
+// migrateCategories moves databases where categories held a row for every
+// post and tag to the catalogue of categories, with post_categories linking
+// posts to them. Tags that differ only in case or punctuation become one
+// category, named as the tag was first written. The tags kept with post
+// revisions and drafts become slugs too.
+func migrateCategories(db *sql.DB) error {
+	old, err := hasColumn(db, "categories", "postid")
+	if err != nil || !old {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type link struct {
+		postID int
+		tag    string
+	}
+	var tags []string
+	var links []link
+	rows, err := tx.Query("SELECT COALESCE(postid, 0), tag FROM categories ORDER BY id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l link
+		if err := rows.Scan(&l.postID, &l.tag); err != nil {
+			rows.Close()
+			return err
+		}
+		l.tag = strings.TrimSpace(l.tag)
+		if l.tag == "" {
+			continue
+		}
+		if !contains(tags, l.tag) {
+			tags = append(tags, l.tag)
+		}
+		links = append(links, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// tags without a letter or digit are numbered once the slugs of all the
+	// others are known, so that a number never takes the slug of a real tag
+	slugs := make(map[string]string)
+	taken := make(map[string]bool)
+	for _, tag := range tags {
+		if slug := module.Slugify(tag); slug != "" {
+			slugs[tag] = slug
+			taken[slug] = true
+		}
+	}
+	n := 0
+	for _, tag := range tags {
+		if slugs[tag] != "" {
+			continue
+		}
+		slug := ""
+		for slug == "" || taken[slug] {
+			n++
+			slug = fmt.Sprintf("category-%d", n)
+		}
+		slugs[tag] = slug
+		taken[slug] = true
+	}
+	var names, order []string
+	for _, tag := range tags {
+		if !contains(order, slugs[tag]) {
+			order = append(order, slugs[tag])
+			names = append(names, tag)
+		}
+	}
+	log.Printf("repository:migrateCategories: %d tags on %d posts become %d categories\n", len(slugs), len(links), len(order))
+
+	if _, err := tx.Exec(strings.Replace(categoryTable, `"categories"`, `"categories_new"`, 1)); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i, slug := range order {
+		// names differing only in case have the same slug, so they are unique
+		if _, err := tx.Exec("INSERT INTO categories_new (name, slug, created_at) VALUES (?, ?, ?)", names[i], slug, now); err != nil {
+			return err
+		}
+	}
+	for _, l := range links {
+		if l.postID == 0 {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO post_categories (post_id, category_id) SELECT ?, id FROM categories_new WHERE slug = ?", l.postID, slugs[l.tag]); err != nil {
+			return err
+		}
+	}
+	for _, query := range []string{`DROP TABLE "categories"`, `ALTER TABLE "categories_new" RENAME TO "categories"`} {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"post_revisions", "drafts"} {
+		if err := retag(tx, table, slugs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// retag turns the space separated tags of every row of table into slugs.
+func retag(tx *sql.Tx, table string, slugs map[string]string) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, tags FROM %q WHERE tags != ''", table))
+	if err != nil {
+		return err
+	}
+	updated := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var tags string
+		if err := rows.Scan(&id, &tags); err != nil {
+			rows.Close()
+			return err
+		}
+		var out []string
+		for _, tag := range strings.Fields(tags) {
+			slug, ok := slugs[tag]
+			if !ok {
+				slug = module.Slugify(tag)
+			}
+			if slug != "" && !contains(out, slug) {
+				out = append(out, slug)
+			}
+		}
+		if s := strings.Join(out, " "); s != tags {
+			updated[id] = s
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, tags := range updated {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %q SET tags = ? WHERE id = ?", table), tags, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
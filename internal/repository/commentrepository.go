@@ -9,7 +9,7 @@ import (
 
 type Comment interface {
 	CreateComment(*module.Comment) error
-	FindCommentsInPostID(postid int) ([]module.Comment, error)
+	FindCommentsInPostID(postid int, q module.PageQuery) ([]module.Comment, error)
 	GetPostIdByCommentId(commentID int) (*module.Comment, error)
 	GetCommentLikesByPostID(postID int) (map[int][]int, error)
 	GetCommentDislikesByPostID(postID int) (map[int][]int, error)
@@ -19,6 +19,9 @@ type Comment interface {
 	RemoveDislikeByComment(commentID int, userID int) error
 	CommentHasLike(commentID int, userID int) error
 	CommentHasDislike(commentID int, userID int) error
+	GetCommentVote(commentID int, userID int) (*module.Vote, error)
+	GetCommentByID(commentID int) (*module.Comment, error)
+	DeleteComment(commentID int) error
 }
 
 type CommentRepository struct {
@@ -31,9 +34,12 @@ func newCommentRepostiroy(db *sql.DB) *CommentRepository {
 	}
 }
 
+// GetPostIdByCommentId returns the post of a comment, or sql.ErrNoRows when
+// the post is deleted or not published yet.
 func (r *CommentRepository) GetPostIdByCommentId(commentID int) (*module.Comment, error) {
 	c := &module.Comment{}
-	err := r.db.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&c.PostID)
+	err := r.db.QueryRow(`SELECT c.post_id FROM comments c JOIN posts p ON p.id = c.post_id
+	WHERE c.id = ? AND p.deleted_at IS NULL AND p.publish_at IS NULL`, commentID).Scan(&c.PostID)
 	if err == sql.ErrNoRows {
 		log.Println("error:rep: no rows found in GetPostIdByCommentId")
 		return nil, err
@@ -194,32 +200,78 @@ func (r *CommentRepository) CommentHasDislike(commentID int, userID int) error {
 	return nil
 }
 
+// GetCommentVote returns the counters of a comment and the vote userID gave it.
+func (r *CommentRepository) GetCommentVote(commentID int, userID int) (*module.Vote, error) {
+	query := `SELECT c.likes, c.dislikes,
+	CASE WHEN EXISTS (SELECT 1 FROM likes WHERE comment_id = c.id AND user_id = ?) THEN 'like'
+	WHEN EXISTS (SELECT 1 FROM dislikes WHERE comment_id = c.id AND user_id = ?) THEN 'dislike'
+	ELSE '' END
+	FROM comments c JOIN posts p ON p.id = c.post_id
+	WHERE c.id = ? AND p.deleted_at IS NULL AND p.publish_at IS NULL`
+	v := &module.Vote{}
+	if err := r.db.QueryRow(query, userID, userID, commentID).Scan(&v.Likes, &v.Dislikes, &v.State); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (r *CommentRepository) GetCommentByID(commentID int) (*module.Comment, error) {
+	c := &module.Comment{}
+	err := r.db.QueryRow("SELECT id, author_id, author, post_id, message FROM comments WHERE id = ?", commentID).Scan(&c.ID, &c.AuthorID, &c.Author, &c.PostID, &c.Message)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DeleteComment removes a comment together with its likes and dislikes.
+func (r *CommentRepository) DeleteComment(commentID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"DELETE FROM likes WHERE comment_id = ?",
+		"DELETE FROM dislikes WHERE comment_id = ?",
+		"DELETE FROM comments WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, commentID); err != nil {
+			log.Println("error:rep:DeleteComment: ", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (r *CommentRepository) CreateComment(c *module.Comment) error {
-	if _, err := r.db.Exec("INSERT INTO comments (author_id, author, post_id, message, date) VALUES(?, ?, ?, ?, ?)", c.AuthorID, c.Author, c.PostID, c.Message, c.Date); err != nil {
+	if _, err := r.db.Exec("INSERT INTO comments (author_id, author, post_id, message, date, ts) VALUES(?, ?, ?, ?, ?, ?)", c.AuthorID, c.Author, c.PostID, c.Message, c.Date, c.Date.Unix()); err != nil {
 		log.Print(err)
 		return err
 	}
 	return nil
 }
 
-func (r *CommentRepository) FindCommentsInPostID(PostId int) ([]module.Comment, error) {
-	u := module.User{}
-	comments := u.Comments
-	c := module.Comment{}
-	rows, err := r.db.Query("SELECT id, author, message, date, likes, dislikes FROM comments WHERE post_id = ?", PostId)
-	if err == sql.ErrNoRows {
-		log.Println("error:rep: no rows found in FindCommentsInPostID")
-		return nil, err
-	}
+// FindCommentsInPostID returns a page of the comments of a post, oldest first.
+func (r *CommentRepository) FindCommentsInPostID(PostId int, q module.PageQuery) ([]module.Comment, error) {
+	var comments []module.Comment
+	cond, order, args, reversed := keyset(q, "", "ts", "id", false)
+	rows, err := r.db.Query("SELECT id, author_id, author, message, date, likes, dislikes FROM comments WHERE post_id = ? AND "+cond+order, append([]interface{}{PostId}, args...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		if err := rows.Scan(&c.ID, &c.Author, &c.Message, &c.Date, &c.Likes, &c.Dislikes); err != nil {
+		c := module.Comment{}
+		if err := rows.Scan(&c.ID, &c.AuthorID, &c.Author, &c.Message, &c.Date, &c.Likes, &c.Dislikes); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
 	}
-	return comments, nil
+	if reversed {
+		for i, j := 0, len(comments)-1; i < j; i, j = i+1, j-1 {
+			comments[i], comments[j] = comments[j], comments[i]
+		}
+	}
+	return comments, rows.Err()
 }
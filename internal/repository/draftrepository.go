@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+type Drafts interface {
+	SaveDraft(d *module.Draft) error
+	GetDraft(id, userID int) (*module.Draft, error)
+	GetDraftsByUserID(userID int) ([]module.Draft, error)
+	CountDrafts(userID int) (int, error)
+	DeleteDraft(id, userID int) error
+}
+
+// SaveDraft creates the draft when it has no ID yet, and otherwise updates it
+// if it belongs to d.UserID; sql.ErrNoRows means it doesn't.
+func (r *PostRepository) SaveDraft(d *module.Draft) error {
+	if d.ID == 0 {
+		query := "INSERT INTO drafts(user_id, title, message, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"
+		if err := r.db.QueryRow(query, d.UserID, d.Title, d.Message, d.Tags, d.CreatedAt, d.UpdatedAt).Scan(&d.ID); err != nil {
+			log.Println("error:postRepo:SaveDraft: ", err)
+			return err
+		}
+		return nil
+	}
+	res, err := r.db.Exec("UPDATE drafts SET title = ?, message = ?, tags = ?, updated_at = ? WHERE id = ? AND user_id = ?", d.Title, d.Message, d.Tags, d.UpdatedAt, d.ID, d.UserID)
+	if err != nil {
+		log.Println("error:postRepo:SaveDraft: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *PostRepository) GetDraft(id, userID int) (*module.Draft, error) {
+	d := &module.Draft{}
+	var created, updated sql.NullTime
+	err := r.db.QueryRow("SELECT id, user_id, title, message, tags, created_at, updated_at FROM drafts WHERE id = ? AND user_id = ?", id, userID).Scan(
+		&d.ID, &d.UserID, &d.Title, &d.Message, &d.Tags, &created, &updated)
+	if err == sql.ErrNoRows {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.CreatedAt, d.UpdatedAt = created.Time, updated.Time
+	return d, nil
+}
+
+// GetDraftsByUserID returns the drafts of the user, the last edited first.
+func (r *PostRepository) GetDraftsByUserID(userID int) ([]module.Draft, error) {
+	rows, err := r.db.Query("SELECT id, user_id, title, message, tags, created_at, updated_at FROM drafts WHERE user_id = ? ORDER BY updated_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var drafts []module.Draft
+	for rows.Next() {
+		var d module.Draft
+		var created, updated sql.NullTime
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Title, &d.Message, &d.Tags, &created, &updated); err != nil {
+			return nil, err
+		}
+		d.CreatedAt, d.UpdatedAt = created.Time, updated.Time
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+func (r *PostRepository) CountDrafts(userID int) (int, error) {
+	var n int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM drafts WHERE user_id = ?", userID).Scan(&n)
+	return n, err
+}
+
+// DeleteDraft removes a draft of the user; sql.ErrNoRows means there is none.
+func (r *PostRepository) DeleteDraft(id, userID int) error {
+	res, err := r.db.Exec("DELETE FROM drafts WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+type LoginAttempts interface {
+	GetLoginAttempt(key string) (*module.LoginAttempt, error)
+	AddLoginFailure(key string, now, resetBefore time.Time) (int, error)
+	LockLogin(key string, until time.Time) error
+	GetLoginAttempts() ([]module.LoginAttempt, error)
+	DeleteLoginAttempt(key string) error
+	DeleteLoginAttemptsBefore(t time.Time) error
+}
+
+func (r *AuthRepository) GetLoginAttempt(key string) (*module.LoginAttempt, error) {
+	a := &module.LoginAttempt{}
+	var lastFailure, lockedUntil sql.NullTime
+	err := r.db.QueryRow(
+		"SELECT key, failures, last_failure, locked_until FROM login_attempts WHERE key = ?",
+		key,
+	).Scan(&a.Key, &a.Failures, &lastFailure, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	a.LastFailure, a.LockedUntil = lastFailure.Time, lockedUntil.Time
+	return a, nil
+}
+
+// AddLoginFailure counts a failed sign-in and returns the number of failures in a row.
+// Failures older than resetBefore are forgotten.
+func (r *AuthRepository) AddLoginFailure(key string, now, resetBefore time.Time) (int, error) {
+	query := `INSERT INTO login_attempts (key, failures, last_failure) VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN last_failure < ? THEN 1 ELSE failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING failures`
+	var failures int
+	if err := r.db.QueryRow(query, key, now, resetBefore).Scan(&failures); err != nil {
+		log.Println("error:authRepo:AddLoginFailure: ", err)
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (r *AuthRepository) LockLogin(key string, until time.Time) error {
+	if _, err := r.db.Exec("UPDATE login_attempts SET locked_until = ? WHERE key = ?", until, key); err != nil {
+		log.Println("error:authRepo:LockLogin: ", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthRepository) GetLoginAttempts() ([]module.LoginAttempt, error) {
+	rows, err := r.db.Query("SELECT key, failures, last_failure, locked_until FROM login_attempts ORDER BY last_failure DESC")
+	if err != nil {
+		log.Println("error:authRepo:GetLoginAttempts: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var attempts []module.LoginAttempt
+	for rows.Next() {
+		a := module.LoginAttempt{}
+		var lastFailure, lockedUntil sql.NullTime
+		if err := rows.Scan(&a.Key, &a.Failures, &lastFailure, &lockedUntil); err != nil {
+			return nil, err
+		}
+		a.LastFailure, a.LockedUntil = lastFailure.Time, lockedUntil.Time
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+func (r *AuthRepository) DeleteLoginAttempt(key string) error {
+	if _, err := r.db.Exec("DELETE FROM login_attempts WHERE key = ?", key); err != nil {
+		log.Println("error:authRepo:DeleteLoginAttempt: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteLoginAttemptsBefore drops counters whose last failure is older than t and which are not locked.
+func (r *AuthRepository) DeleteLoginAttemptsBefore(t time.Time) error {
+	query := "DELETE FROM login_attempts WHERE last_failure < ? AND (locked_until IS NULL OR locked_until < ?)"
+	if _, err := r.db.Exec(query, t, time.Now()); err != nil {
+		log.Println("error:authRepo:DeleteLoginAttemptsBefore: ", err)
+		return err
+	}
+	return nil
+}
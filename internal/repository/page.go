@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// keyset returns the condition, order and limit that select the page q asks
+// for from a listing sorted by score, if given, then the ts and id columns,
+// highest first when desc is set. Before a cursor the rows are read
+// backwards, from the cursor on, so they come out reversed; reversed tells
+// the caller to turn them around.
+//
+// ts is the date as unix time: dates are stored as text with the zone of the
+// server that wrote them, which doesn't sort across zones.
+func keyset(q module.PageQuery, score, ts, id string, desc bool) (cond, order string, args []interface{}, reversed bool) {
+	keys, params := []string{ts, id}, "?, ?"
+	if score != "" {
+		keys, params = append([]string{score}, keys...), "?, "+params
+	}
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+	cursor := q.After
+	if q.Before != nil {
+		cursor, reversed = q.Before, true
+		cmp, dir = flip[cmp], flip[dir]
+	}
+	cond = "1"
+	if cursor != nil {
+		cond = "(" + strings.Join(keys, ", ") + ") " + cmp + " (" + params + ")"
+		if score != "" {
+			args = append(args, cursor.Score)
+		}
+		args = append(args, cursor.Date.Unix(), cursor.ID)
+	}
+	order = " ORDER BY " + strings.Join(keys, " "+dir+", ") + " " + dir + " LIMIT ?"
+	return cond, order, append(args, q.Limit), reversed
+}
+
+var flip = map[string]string{"<": ">", ">": "<", "ASC": "DESC", "DESC": "ASC"}
+
+// postRanks are the scores ranked listings of posts are sorted by.
+var postRanks = map[string]string{
+	module.SortTop:           "(likes - dislikes)",
+	module.SortControversial: "controversy(likes, dislikes)",
+	module.SortHot:           "hot(likes, dislikes, ts)",
+}
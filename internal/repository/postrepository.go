@@ -5,20 +5,27 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/ive663/forum/internal/module"
 )
 
 type Post interface {
-	CreatePost(*module.Post) (int, error)
-	CreateCategory(*module.Category) error
-	GetPostByCategory(category string) ([]module.Post, error)
-	GetOldPosts() ([]module.Post, error)
-	GetNewPosts() ([]module.Post, error)
+	CreatePost(p *module.Post, categories []string, attachments []module.Attachment) (int, error)
+	GetPosts(filter module.PostFilter, sort module.PostSort, q module.PageQuery) ([]module.Post, error)
 	GetPostIdByUserId(id int) (*module.Post, error)
 	GetPostByPostId(id int) (*module.Post, error)
 	GetAllCategoryByPostId(postid int) ([]module.Category, error)
-	GetPostsByUserId(id int) ([]module.Post, error)
+	GetCategoriesByPostIDs(ids []int) (map[int][]module.Category, error)
+	UpdatePost(rev *module.PostRevision) error
+	DeletePost(postID int, now time.Time) ([]module.Attachment, error)
+	GetScheduledPosts(userID int) ([]module.Post, error)
+	PublishDuePosts(now time.Time) (int64, error)
+	GetPostRevisions(postID int) ([]module.PostRevision, error)
+	GetAttachment(id int) (*module.Attachment, error)
+	GetAttachmentsByPostID(postID int) ([]module.Attachment, error)
+	Drafts
 
 	///  added new interfaces for likes and dislikes ///
 	GetLikesCountByPostID(postID int) (*module.Post, error)
@@ -30,15 +37,9 @@ type Post interface {
 	///=================///
 	PostHasLike(postId int, userId int) error
 	PostHasDisLike(postId int, userId int) error
-	GetMyLikedPosts(userID int) ([]module.Post, error)
+	GetPostVote(postID int, userID int) (*module.Vote, error)
 	///=================///
 	GetAllPostsByUserId(id int) ([]module.Post, error)
-	/// added new interfaces for sorting by likes///
-	GetPostsByLikesHigh() ([]module.Post, error)
-	GetPostsByLikesLow() ([]module.Post, error)
-	///=================///
-	GetPostsByDisLikesHigh() ([]module.Post, error)
-	GetPostsByDisLikesLow() ([]module.Post, error)
 }
 
 var ErrRecordNotFound = errors.New("record not found")
@@ -53,82 +54,10 @@ func newPostRepository(db *sql.DB) *PostRepository {
 	}
 }
 
-/// added new interfaces for sorting by likes///
-
-func (r *PostRepository) GetPostsByDisLikesLow() ([]module.Post, error) {
-	var posts []module.Post
-	rows, err := r.db.Query("SELECT id, title, author_id, message, likes, dislikes, date FROM posts ORDER BY dislikes ASC")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		post := module.Post{}
-		if err := rows.Scan(&post.ID, &post.Title, &post.Message, &post.AuthorID, &post.Likes, &post.Dislikes, &post.Date); err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-func (r *PostRepository) GetPostsByDisLikesHigh() ([]module.Post, error) {
-	var posts []module.Post
-	rows, err := r.db.Query("SELECT id, title, author_id, message, likes, dislikes, date FROM posts ORDER BY dislikes DESC")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		post := module.Post{}
-		if err := rows.Scan(&post.ID, &post.Title, &post.Message, &post.AuthorID, &post.Likes, &post.Dislikes, &post.Date); err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-func (r *PostRepository) GetPostsByLikesLow() ([]module.Post, error) {
-	var posts []module.Post
-	rows, err := r.db.Query("SELECT id, title, author_id, message, likes, dislikes, date FROM posts ORDER BY likes ASC")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		post := module.Post{}
-		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Message, &post.Likes, &post.Dislikes, &post.Date); err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-func (r *PostRepository) GetPostsByLikesHigh() ([]module.Post, error) {
-	var posts []module.Post
-	rows, err := r.db.Query("SELECT id, title, author_id, message,  likes, dislikes, date FROM posts ORDER BY likes DESC")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		post := module.Post{}
-		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Message, &post.Likes, &post.Dislikes, &post.Date); err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-///=================///
-
 // Get all posts by user id
 func (r *PostRepository) GetAllPostsByUserId(id int) ([]module.Post, error) {
 	var posts []module.Post
-	rows, err := r.db.Query("SELECT id, title, message, author_id, likes, dislikes, date FROM posts WHERE author_id = ?", id)
+	rows, err := r.db.Query("SELECT id, title, message, author_id, likes, dislikes, date FROM posts WHERE author_id = ? AND deleted_at IS NULL AND publish_at IS NULL", id)
 	if err != nil {
 		log.Print(err)
 		return nil, err
@@ -145,42 +74,6 @@ func (r *PostRepository) GetAllPostsByUserId(id int) ([]module.Post, error) {
 	return posts, nil
 }
 
-func (r *PostRepository) GetMyLikedPosts(userID int) ([]module.Post, error) {
-	var posts []module.Post
-	queryLike := "SELECT post_id FROM likes WHERE user_id = ?"
-	queryPosts := "SELECT * FROM posts WHERE id = ?"
-	rowsLike, err := r.db.Query(queryLike, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rowsLike.Close()
-	for rowsLike.Next() {
-		var postid int
-		if err := rowsLike.Scan(&postid); err != nil {
-			if err == sql.ErrNoRows {
-				return nil, nil
-			}
-			if postid == 0 {
-				continue
-			}
-			return nil, err
-		}
-		rowsPosts, err := r.db.Query(queryPosts, postid)
-		if err != nil {
-			return nil, err
-		}
-		defer rowsPosts.Close()
-		for rowsPosts.Next() {
-			p := module.Post{}
-			if err := rowsPosts.Scan(&p.ID, &p.Title, &p.AuthorID, &p.Author, &p.Message, &p.Likes, &p.Dislikes, &p.CategoryID, &p.Date); err != nil {
-				return nil, err
-			}
-			posts = append(posts, p)
-		}
-	}
-	return posts, nil
-}
-
 // add like to post by post id and return error
 func (r *PostRepository) AddLikeByPost(postID int, userID int) error {
 	query := "INSERT INTO likes(post_id, user_id) VALUES (?, ?)"
@@ -263,6 +156,20 @@ func (r *PostRepository) PostHasDisLike(postId int, userId int) error {
 	return nil
 }
 
+// GetPostVote returns the counters of a post and the vote userID gave it.
+func (r *PostRepository) GetPostVote(postID int, userID int) (*module.Vote, error) {
+	query := `SELECT likes, dislikes,
+	CASE WHEN EXISTS (SELECT 1 FROM likes WHERE post_id = posts.id AND user_id = ?) THEN 'like'
+	WHEN EXISTS (SELECT 1 FROM dislikes WHERE post_id = posts.id AND user_id = ?) THEN 'dislike'
+	ELSE '' END
+	FROM posts WHERE id = ? AND deleted_at IS NULL AND publish_at IS NULL`
+	v := &module.Vote{}
+	if err := r.db.QueryRow(query, userID, userID, postID).Scan(&v.Likes, &v.Dislikes, &v.State); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
 // get dislikes count by post id and return error
 func (r *PostRepository) GetDisLikesCountByPostID(postID int) (*module.Post, error) {
 	var post module.Post
@@ -275,135 +182,373 @@ func (r *PostRepository) GetDisLikesCountByPostID(postID int) (*module.Post, err
 
 ///===================================================///
 
-func (r *PostRepository) CreatePost(p *module.Post) (int, error) {
-	query := "INSERT INTO posts(title, author_id, author, message, category_id, date) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"
+// CreatePost adds the post in the categories, given by slug, with its
+// attachments, whose ids are filled in. Either all of it is stored or none.
+func (r *PostRepository) CreatePost(p *module.Post, categories []string, attachments []module.Attachment) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	query := "INSERT INTO posts(title, author_id, author, message, category_id, date, ts, publish_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id"
+	publishAt := sql.NullTime{Time: p.PublishAt, Valid: !p.PublishAt.IsZero()}
 	var id int
-	if err := r.db.QueryRow(query, p.Title, p.AuthorID, p.Author, p.Message, p.CategoryID, p.Date).Scan(&id); err != nil {
-		log.Print(err)
+	if err := tx.QueryRow(query, p.Title, p.AuthorID, p.Author, p.Message, p.CategoryID, p.Date, p.Date.Unix(), publishAt).Scan(&id); err != nil {
+		log.Println("error:postRepo:CreatePost: ", err)
 		return 0, err
 	}
-	return id, nil
+	for _, slug := range categories {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO post_categories (post_id, category_id) SELECT ?, id FROM categories WHERE slug = ?", id, slug); err != nil {
+			log.Println("error:postRepo:CreatePost: ", err)
+			return 0, err
+		}
+	}
+	for i := range attachments {
+		attachments[i].PostID = id
+		if err := createAttachment(tx, &attachments[i]); err != nil {
+			log.Println("error:postRepo:CreatePost: ", err)
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
 }
 
-func (r *PostRepository) CreateCategory(c *module.Category) error {
-	if _, err := r.db.Exec("INSERT INTO categories (tag, postid) VALUES(?, ?)", c.Tag, c.PostID); err != nil {
-		return err
+// GetPosts returns a page of the published posts that pass filter.
+func (r *PostRepository) GetPosts(filter module.PostFilter, sort module.PostSort, q module.PageQuery) ([]module.Post, error) {
+	where, args := postFilter(filter)
+	return r.queryPostPage(where, args, sort, q)
+}
+
+// postFilter turns filter into the conditions a post must meet, all in one
+// WHERE clause.
+func postFilter(f module.PostFilter) (string, []interface{}) {
+	conds := []string{"1"}
+	var args []interface{}
+	if len(f.Categories) > 0 {
+		in := strings.TrimSuffix(strings.Repeat("?, ", len(f.Categories)), ", ")
+		for _, c := range f.Categories {
+			args = append(args, c)
+		}
+		if f.AllCategories {
+			conds = append(conds, "(SELECT count(*) FROM post_categories pc JOIN categories c ON c.id = pc.category_id WHERE pc.post_id = posts.id AND c.slug IN ("+in+")) = ?")
+			args = append(args, len(f.Categories))
+		} else {
+			conds = append(conds, "id IN (SELECT pc.post_id FROM post_categories pc JOIN categories c ON c.id = pc.category_id WHERE c.slug IN ("+in+"))")
+		}
 	}
-	return nil
+	if f.Mine {
+		conds = append(conds, "author_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Liked {
+		conds = append(conds, "id IN (SELECT post_id FROM likes WHERE user_id = ?)")
+		args = append(args, f.UserID)
+	}
+	if f.Disliked {
+		conds = append(conds, "id IN (SELECT post_id FROM dislikes WHERE user_id = ?)")
+		args = append(args, f.UserID)
+	}
+	if f.Commented {
+		conds = append(conds, "id IN (SELECT post_id FROM comments WHERE author_id = ?)")
+		args = append(args, f.UserID)
+	}
+	if f.NoComments {
+		conds = append(conds, "NOT EXISTS (SELECT 1 FROM comments WHERE post_id = posts.id)")
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "ts >= ?")
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "ts < ?")
+		args = append(args, f.Until.Unix())
+	}
+	return strings.Join(conds, " AND "), args
 }
 
-func (r *PostRepository) GetNewPosts() ([]module.Post, error) {
-	var posts []module.Post
-	query := "SELECT id, title, author_id, author, message, likes, dislikes, date FROM posts ORDER by date DESC;"
-	rows, err := r.db.Query(query)
+// queryPostPage returns a page of the published posts that match where, in
+// the order sort asks for.
+func (r *PostRepository) queryPostPage(where string, args []interface{}, sort module.PostSort, q module.PageQuery) ([]module.Post, error) {
+	rank := postRanks[sort.By]
+	if !sort.Since.IsZero() {
+		where += " AND ts >= ?"
+		args = append(args, sort.Since.Unix())
+	}
+	cond, order, pageArgs, reversed := keyset(q, rank, "ts", "id", sort.By != module.SortOld)
+	if rank == "" {
+		rank = "0"
+	}
+	query := "SELECT id, title, author_id, author, message, likes, dislikes, category_id, date, " + rank + " FROM posts WHERE deleted_at IS NULL AND publish_at IS NULL AND " + where + " AND " + cond + order
+	rows, err := r.db.Query(query, append(args, pageArgs...)...)
 	if err != nil {
+		log.Println("error:postRepo:queryPostPage: ", err)
 		return nil, err
 	}
+	defer rows.Close()
+	var posts []module.Post
 	for rows.Next() {
-		post := module.Post{}
-		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Author, &post.Message, &post.Likes, &post.Dislikes, &post.Date); err != nil {
+		var post module.Post
+		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Author, &post.Message, &post.Likes, &post.Dislikes, &post.CategoryID, &post.Date, &post.Rank); err != nil {
 			return nil, err
 		}
 		posts = append(posts, post)
-
 	}
-	return posts, nil
+	if reversed {
+		for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+			posts[i], posts[j] = posts[j], posts[i]
+		}
+	}
+	return posts, rows.Err()
 }
 
-func (r *PostRepository) GetPostByCategory(category string) ([]module.Post, error) {
-	var posts []module.Post
-	query := "SELECT * FROM posts WHERE id IN (SELECT postid FROM categories WHERE tag = ?);"
-	rows, err := r.db.Query(query, category)
+func (r *PostRepository) GetPostIdByUserId(id int) (*module.Post, error) {
+	var p module.Post
+	err := r.db.QueryRow("SELECT id FROM posts WHERE author_id = ?", id).Scan(
+		&p.AuthorID)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("record not found")
+	}
 	if err != nil {
 		return nil, err
 	}
+	return &p, nil
+}
+
+func (r *PostRepository) GetPostByPostId(postid int) (*module.Post, error) {
+	p := &module.Post{}
+	var edited, deleted, publishAt sql.NullTime
+	err := r.db.QueryRow("SELECT id, title, author_id, author, message, date, edited_at, deleted_at, publish_at FROM posts WHERE id = ?", postid).Scan(&p.ID, &p.Title, &p.AuthorID, &p.Author, &p.Message, &p.Date, &edited, &deleted, &publishAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrRecordNotFound
 	}
-	for rows.Next() {
-		var post module.Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Author, &post.Message, &post.Likes, &post.Dislikes, &post.CategoryID, &post.Date); err != nil {
+	if err != nil {
+		return nil, err
+	}
+	p.EditedAt = edited.Time
+	p.DeletedAt = deleted.Time
+	p.PublishAt = publishAt.Time
+	return p, nil
+}
+
+// GetAllCategoryByPostId returns the categories of a post in catalogue order.
+func (r *PostRepository) GetAllCategoryByPostId(postid int) ([]module.Category, error) {
+	queryCategory := `SELECT c.id, c.name, c.slug, c.description, c.color, c.position
+	FROM post_categories pc JOIN categories c ON c.id = pc.category_id
+	WHERE pc.post_id = ? ORDER BY c.position, c.name COLLATE NOCASE`
+	categoryRows, err := r.db.Query(queryCategory, postid)
+	if err != nil {
+		return nil, err
+	}
+	defer categoryRows.Close()
+	var category []module.Category
+	for categoryRows.Next() {
+		var c module.Category
+		if err := categoryRows.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.Color, &c.Position); err != nil {
 			return nil, err
 		}
-		posts = append(posts, post)
+		category = append(category, c)
 	}
-	return posts, nil
+	return category, categoryRows.Err()
 }
 
-// !!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!1 / /
-func (r *PostRepository) GetOldPosts() ([]module.Post, error) {
-	var posts []module.Post
-	query := "SELECT id, title, author_id, author, message, likes, dislikes, date FROM posts ORDER by date;"
-	rows, err := r.db.Query(query)
+// GetCategoriesByPostIDs returns the categories of all the posts at once,
+// keyed by post id, each in catalogue order.
+func (r *PostRepository) GetCategoriesByPostIDs(ids []int) (map[int][]module.Category, error) {
+	categories := make(map[int][]module.Category)
+	if len(ids) == 0 {
+		return categories, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `SELECT pc.post_id, c.id, c.name, c.slug, c.description, c.color, c.position
+	FROM post_categories pc JOIN categories c ON c.id = pc.category_id
+	WHERE pc.post_id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + `)
+	ORDER BY c.position, c.name COLLATE NOCASE`
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error getting old posts: %w", err)
+		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		post := module.Post{}
-		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Author, &post.Message, &post.Date); err != nil {
+		var postID int
+		var c module.Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name, &c.Slug, &c.Description, &c.Color, &c.Position); err != nil {
 			return nil, err
 		}
-		posts = append(posts, post)
+		categories[postID] = append(categories[postID], c)
+	}
+	return categories, rows.Err()
+}
 
+// UpdatePost stores rev as the new content of its post and keeps it in the
+// history. The first edit also keeps the original, so that every post with
+// revisions starts from what was first published.
+func (r *PostRepository) UpdatePost(rev *module.PostRevision) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
 	}
-	return posts, nil
+	defer tx.Rollback()
+
+	original := `INSERT INTO post_revisions(post_id, editor_id, editor, title, message, tags, created_at)
+	SELECT id, author_id, author, title, message, COALESCE((SELECT group_concat(c.slug, ' ') FROM post_categories pc JOIN categories c ON c.id = pc.category_id WHERE pc.post_id = posts.id), ''), date
+	FROM posts WHERE id = ?1 AND NOT EXISTS (SELECT 1 FROM post_revisions WHERE post_id = ?1)`
+	if _, err := tx.Exec(original, rev.PostID); err != nil {
+		log.Println("error:postRepo:UpdatePost: ", err)
+		return err
+	}
+	res, err := tx.Exec("UPDATE posts SET title = ?, message = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL", rev.Title, rev.Message, rev.Date, rev.PostID)
+	if err != nil {
+		log.Println("error:postRepo:UpdatePost: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE post_id = ?", rev.PostID); err != nil {
+		return err
+	}
+	for _, slug := range rev.Tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO post_categories (post_id, category_id) SELECT ?, id FROM categories WHERE slug = ?", rev.PostID, slug); err != nil {
+			return err
+		}
+	}
+	query := "INSERT INTO post_revisions(post_id, editor_id, editor, title, message, tags, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(query, rev.PostID, rev.EditorID, rev.Editor, rev.Title, rev.Message, strings.Join(rev.Tags, " "), rev.Date); err != nil {
+		log.Println("error:postRepo:UpdatePost: ", err)
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *PostRepository) GetPostsByUserId(id int) ([]module.Post, error) {
-	var posts []module.Post
-	query := "SELECT * FROM posts WHERE author_id = ?"
-	rows, err := r.db.Query(query, id)
+// DeletePost hides a post. Its comments, votes and revisions stay; its
+// attachments are removed and returned, for their blobs to be deleted.
+func (r *PostRepository) DeletePost(postID int, now time.Time) ([]module.Attachment, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE posts SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", now, postID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	attachments, err := queryAttachments(tx, "WHERE post_id = ?", postID)
+	if err != nil {
+		log.Println("error:postRepo:DeletePost: ", err)
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM attachments WHERE post_id = ?", postID); err != nil {
+		log.Println("error:postRepo:DeletePost: ", err)
+		return nil, err
+	}
+	return attachments, tx.Commit()
+}
+
+// GetScheduledPosts returns the posts of the user that wait for their
+// publish time, the next one first.
+func (r *PostRepository) GetScheduledPosts(userID int) ([]module.Post, error) {
+	rows, err := r.db.Query("SELECT id, title, author_id, author, message, date, publish_at FROM posts WHERE author_id = ? AND publish_at IS NOT NULL AND deleted_at IS NULL ORDER BY publish_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var posts []module.Post
 	for rows.Next() {
 		var post module.Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Author, &post.Message, &post.Likes, &post.Dislikes, &post.CategoryID, &post.Date); err != nil {
+		var publishAt sql.NullTime
+		if err := rows.Scan(&post.ID, &post.Title, &post.AuthorID, &post.Author, &post.Message, &post.Date, &publishAt); err != nil {
 			return nil, err
 		}
+		post.PublishAt = publishAt.Time
 		posts = append(posts, post)
 	}
-	return posts, nil
+	return posts, rows.Err()
 }
 
-func (r *PostRepository) GetPostIdByUserId(id int) (*module.Post, error) {
-	var p module.Post
-	err := r.db.QueryRow("SELECT id FROM posts WHERE author_id = ?", id).Scan(
-		&p.AuthorID)
-	if err == sql.ErrNoRows {
-		return nil, errors.New("record not found")
+// PublishDuePosts makes the scheduled posts whose time has come visible,
+// dated when they were meant to appear.
+func (r *PostRepository) PublishDuePosts(now time.Time) (int64, error) {
+	res, err := r.db.Exec("UPDATE posts SET date = publish_at, ts = strftime('%s', publish_at), publish_at = NULL WHERE publish_at IS NOT NULL AND publish_at <= ?", now)
+	if err != nil {
+		return 0, err
 	}
+	return res.RowsAffected()
+}
+
+// GetPostRevisions returns the revisions of a post from the oldest, or none
+// if it was never edited.
+func (r *PostRepository) GetPostRevisions(postID int) ([]module.PostRevision, error) {
+	rows, err := r.db.Query("SELECT id, post_id, editor_id, editor, title, message, tags, created_at FROM post_revisions WHERE post_id = ? ORDER BY id", postID)
 	if err != nil {
 		return nil, err
 	}
-	return &p, nil
+	defer rows.Close()
+	var revisions []module.PostRevision
+	for rows.Next() {
+		rev := module.PostRevision{Number: len(revisions) + 1}
+		var tags string
+		var date sql.NullTime
+		if err := rows.Scan(&rev.ID, &rev.PostID, &rev.EditorID, &rev.Editor, &rev.Title, &rev.Message, &tags, &date); err != nil {
+			return nil, err
+		}
+		rev.Tags = strings.Fields(tags)
+		rev.Date = date.Time
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
 }
 
-func (r *PostRepository) GetPostByPostId(postid int) (*module.Post, error) {
-	p := &module.Post{}
-	err := r.db.QueryRow("SELECT id, title, author_id, author, message, date FROM posts WHERE id = ?", postid).Scan(&p.ID, &p.Title, &p.AuthorID, &p.Author, &p.Message, &p.Date)
-	if err == sql.ErrNoRows {
-		return nil, errors.New("record not found")
-	}
+func createAttachment(tx *sql.Tx, a *module.Attachment) error {
+	query := `INSERT INTO attachments(post_id, blob_key, content_type, size, width, height, thumb_key, thumb_type, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`
+	return tx.QueryRow(query, a.PostID, a.Key, a.ContentType, a.Size, a.Width, a.Height, a.ThumbKey, a.ThumbType, a.Date).Scan(&a.ID)
+}
+
+func (r *PostRepository) GetAttachment(id int) (*module.Attachment, error) {
+	attachments, err := queryAttachments(r.db, "WHERE id = ?", id)
 	if err != nil {
 		return nil, err
 	}
-	return p, nil
+	if len(attachments) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &attachments[0], nil
 }
 
-func (r *PostRepository) GetAllCategoryByPostId(postid int) ([]module.Category, error) {
-	queryCategory := "SELECT tag FROM categories WHERE postid = ?"
-	categoryRows, err := r.db.Query(queryCategory, postid)
+func (r *PostRepository) GetAttachmentsByPostID(postID int) ([]module.Attachment, error) {
+	return queryAttachments(r.db, "WHERE post_id = ? ORDER BY id", postID)
+}
+
+// querier is what *sql.DB and *sql.Tx have in common.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryAttachments(q querier, where string, args ...interface{}) ([]module.Attachment, error) {
+	rows, err := q.Query("SELECT id, post_id, blob_key, content_type, size, width, height, thumb_key, thumb_type, created_at FROM attachments "+where, args...)
 	if err != nil {
 		return nil, err
 	}
-	var category []module.Category
-	for categoryRows.Next() {
-		var oneCategory module.Category
-		if err := categoryRows.Scan(&oneCategory.Tag); err != nil {
+	defer rows.Close()
+	var attachments []module.Attachment
+	for rows.Next() {
+		var a module.Attachment
+		var date sql.NullTime
+		if err := rows.Scan(&a.ID, &a.PostID, &a.Key, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.ThumbKey, &a.ThumbType, &date); err != nil {
 			return nil, err
 		}
-		category = append(category, oneCategory)
+		a.Date = date.Time
+		attachments = append(attachments, a)
 	}
-	return category, nil
+	return attachments, rows.Err()
 }
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+type Profile interface {
+	GetProfileByLogin(login string) (*module.User, error)
+	GetUserStats(userID int) (*module.UserStats, error)
+	GetPostsByAuthor(userID, limit, offset int) ([]module.Post, error)
+	GetCommentsByAuthor(userID, limit, offset int) ([]module.Comment, error)
+	UpdateProfile(userID int, bio, avatar string) error
+}
+
+type ProfileRepository struct {
+	db *sql.DB
+}
+
+func newProfileRepository(db *sql.DB) *ProfileRepository {
+	return &ProfileRepository{
+		db: db,
+	}
+}
+
+// GetProfileByLogin returns the public part of an account.
+func (r *ProfileRepository) GetProfileByLogin(login string) (*module.User, error) {
+	u := &module.User{}
+	var createdAt sql.NullTime
+	err := r.db.QueryRow(
+		"SELECT id, username, role, bio, avatar, created_at FROM users WHERE username = ?",
+		login,
+	).Scan(&u.ID, &u.Login, &u.Role, &u.Bio, &u.Avatar, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = createdAt.Time
+	return u, nil
+}
+
+func (r *ProfileRepository) GetUserStats(userID int) (*module.UserStats, error) {
+	s := &module.UserStats{}
+	err := r.db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM posts WHERE author_id = ?1 AND deleted_at IS NULL AND publish_at IS NULL),
+		(SELECT COUNT(*) FROM comments WHERE author_id = ?1 AND post_id NOT IN (SELECT id FROM posts WHERE deleted_at IS NOT NULL)),
+		(SELECT COALESCE(SUM(likes), 0) FROM posts WHERE author_id = ?1 AND deleted_at IS NULL AND publish_at IS NULL) + (SELECT COALESCE(SUM(likes), 0) FROM comments WHERE author_id = ?1),
+		(SELECT COALESCE(SUM(dislikes), 0) FROM posts WHERE author_id = ?1 AND deleted_at IS NULL AND publish_at IS NULL) + (SELECT COALESCE(SUM(dislikes), 0) FROM comments WHERE author_id = ?1)`,
+		userID,
+	).Scan(&s.Posts, &s.Comments, &s.LikesReceived, &s.DislikesReceived)
+	if err != nil {
+		log.Println("error:profileRepo:GetUserStats: ", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *ProfileRepository) GetPostsByAuthor(userID, limit, offset int) ([]module.Post, error) {
+	rows, err := r.db.Query(
+		"SELECT id, title, author_id, author, message, likes, dislikes, date FROM posts WHERE author_id = ? AND deleted_at IS NULL AND publish_at IS NULL ORDER BY date DESC, id DESC LIMIT ? OFFSET ?",
+		userID, limit, offset,
+	)
+	if err != nil {
+		log.Println("error:profileRepo:GetPostsByAuthor: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var posts []module.Post
+	for rows.Next() {
+		p := module.Post{}
+		if err := rows.Scan(&p.ID, &p.Title, &p.AuthorID, &p.Author, &p.Message, &p.Likes, &p.Dislikes, &p.Date); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (r *ProfileRepository) GetCommentsByAuthor(userID, limit, offset int) ([]module.Comment, error) {
+	rows, err := r.db.Query(`SELECT c.id, c.author_id, c.author, c.post_id, p.title, c.message, c.likes, c.dislikes, c.date
+		FROM comments c JOIN posts p ON p.id = c.post_id
+		WHERE c.author_id = ? AND p.deleted_at IS NULL AND p.publish_at IS NULL ORDER BY c.ts DESC, c.id DESC LIMIT ? OFFSET ?`,
+		userID, limit, offset,
+	)
+	if err != nil {
+		log.Println("error:profileRepo:GetCommentsByAuthor: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var comments []module.Comment
+	for rows.Next() {
+		c := module.Comment{}
+		if err := rows.Scan(&c.ID, &c.AuthorID, &c.Author, &c.PostID, &c.PostTitle, &c.Message, &c.Likes, &c.Dislikes, &c.Date); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (r *ProfileRepository) UpdateProfile(userID int, bio, avatar string) error {
+	if _, err := r.db.Exec("UPDATE users SET bio = ?, avatar = ? WHERE id = ?", bio, avatar, userID); err != nil {
+		log.Println("error:profileRepo:UpdateProfile: ", err)
+		return err
+	}
+	return nil
+}
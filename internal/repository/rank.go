@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"database/sql"
+	"math"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the sqlite3 driver with the functions the post listings are
+// ranked by registered on every connection.
+const driverName = "sqlite3_forum"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("hot", hotRank, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("controversy", controversy, true)
+		},
+	})
+}
+
+// hotRank ranks a post by its score and date, given as unix time: every
+// tenfold of the score counts as much as 12.5 hours of age. It only depends
+// on the post, so a listing keeps its order as time passes.
+func hotRank(likes, dislikes, ts int64) float64 {
+	score := float64(likes - dislikes)
+	order := math.Log10(math.Max(math.Abs(score), 1))
+	if score < 0 {
+		order = -order
+	}
+	return order + float64(ts)/45000
+}
+
+// controversy ranks a post by how many votes it got and how evenly they are
+// split: a post with only likes or only dislikes scores 0.
+func controversy(likes, dislikes int64) float64 {
+	if likes <= 0 || dislikes <= 0 {
+		return 0
+	}
+	balance := float64(likes) / float64(dislikes)
+	if likes > dislikes {
+		balance = float64(dislikes) / float64(likes)
+	}
+	return math.Pow(float64(likes+dislikes), balance)
+}
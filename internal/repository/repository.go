@@ -6,12 +6,18 @@ type Repository struct {
 	Post
 	Comment
 	Auth
+	Profile
+	Search
+	Categories
 }
 
 func NewRepository(db *sql.DB) *Repository {
 	return &Repository{
-		Post:    newPostRepository(db),
-		Comment: newCommentRepostiroy(db),
-		Auth:    newAuthRepository(db),
+		Post:       newPostRepository(db),
+		Comment:    newCommentRepostiroy(db),
+		Auth:       newAuthRepository(db),
+		Profile:    newProfileRepository(db),
+		Search:     newSearchRepository(db),
+		Categories: newCategoryRepository(db),
 	}
 }
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+type Roles interface {
+	GetUsers() ([]module.User, error)
+	SetUserRole(userID int, role string) error
+	SetUserRoleByLogin(login, role string) (int64, error)
+}
+
+// GetUsers returns every account for the administration page, without secrets.
+func (r *AuthRepository) GetUsers() ([]module.User, error) {
+	rows, err := r.db.Query("SELECT id, username, email, verified, role, created_at FROM users ORDER BY id")
+	if err != nil {
+		log.Println("error:authRepo:GetUsers: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var users []module.User
+	for rows.Next() {
+		u := module.User{}
+		var createdAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Login, &u.Email, &u.Verified, &u.Role, &createdAt); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = createdAt.Time
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserRole returns sql.ErrNoRows when there is no such user.
+func (r *AuthRepository) SetUserRole(userID int, role string) error {
+	res, err := r.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		log.Println("error:authRepo:SetUserRole: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *AuthRepository) SetUserRoleByLogin(login, role string) (int64, error) {
+	res, err := r.db.Exec("UPDATE users SET role = ? WHERE username = ? AND role <> ?", role, login, role)
+	if err != nil {
+		log.Println("error:authRepo:SetUserRoleByLogin: ", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// ErrSearchUnavailable is returned when SQLite was built without FTS5.
+var ErrSearchUnavailable = errors.New("search is not available")
+
+type Search interface {
+	Search(q module.SearchQuery) ([]module.SearchResult, error)
+}
+
+type SearchRepository struct {
+	db        *sql.DB
+	available bool
+}
+
+func newSearchRepository(db *sql.DB) *SearchRepository {
+	available, err := hasSchema(db, "posts_fts_insert")
+	if err != nil {
+		log.Println("error:searchRepo:newSearchRepository: ", err)
+	}
+	return &SearchRepository{db: db, available: available}
+}
+
+// searchTables index the title and text of posts and the text of comments.
+// Triggers keep them in step with the tables they index, whichever way rows
+// are written.
+var searchTables = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS "posts_fts" USING fts5(title, message, content='posts', content_rowid='id', tokenize='unicode61 remove_diacritics 2')`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS "comments_fts" USING fts5(message, content='comments', content_rowid='id', tokenize='unicode61 remove_diacritics 2')`,
+	`CREATE TRIGGER IF NOT EXISTS "posts_fts_insert" AFTER INSERT ON "posts" BEGIN
+		INSERT INTO posts_fts(rowid, title, message) VALUES (new.id, new.title, new.message);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "posts_fts_delete" AFTER DELETE ON "posts" BEGIN
+		INSERT INTO posts_fts(posts_fts, rowid, title, message) VALUES ('delete', old.id, old.title, old.message);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "posts_fts_update" AFTER UPDATE OF title, message ON "posts" BEGIN
+		INSERT INTO posts_fts(posts_fts, rowid, title, message) VALUES ('delete', old.id, old.title, old.message);
+		INSERT INTO posts_fts(rowid, title, message) VALUES (new.id, new.title, new.message);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "comments_fts_insert" AFTER INSERT ON "comments" BEGIN
+		INSERT INTO comments_fts(rowid, message) VALUES (new.id, new.message);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "comments_fts_delete" AFTER DELETE ON "comments" BEGIN
+		INSERT INTO comments_fts(comments_fts, rowid, message) VALUES ('delete', old.id, old.message);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "comments_fts_update" AFTER UPDATE OF message ON "comments" BEGIN
+		INSERT INTO comments_fts(comments_fts, rowid, message) VALUES ('delete', old.id, old.message);
+		INSERT INTO comments_fts(rowid, message) VALUES (new.id, new.message);
+	END`,
+}
+
+// searchTriggers are dropped when SQLite lacks FTS5, so that posts and
+// comments can still be written. The index is rebuilt when they come back.
+var searchTriggers = []string{"posts_fts_insert", "posts_fts_delete", "posts_fts_update", "comments_fts_insert", "comments_fts_delete", "comments_fts_update"}
+
+// createSearchIndex makes the search tables, and fills them from the posts
+// and comments there whenever the triggers weren't keeping them up to date.
+// Without FTS5 in SQLite, which the sqlite_fts5 build tag adds, search is
+// left off.
+func createSearchIndex(db *sql.DB) error {
+	indexed, err := hasSchema(db, "posts_fts_insert")
+	if err != nil {
+		return err
+	}
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		log.Println("repository:createSearchIndex: SQLite has no FTS5, search is off; build with -tags sqlite_fts5")
+		for _, trigger := range searchTriggers {
+			if _, err := db.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %q", trigger)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, query := range searchTables {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	if !indexed {
+		log.Println("repository:createSearchIndex: indexing posts and comments")
+		for _, query := range []string{
+			`INSERT INTO posts_fts(posts_fts) VALUES ('rebuild')`,
+			`INSERT INTO comments_fts(comments_fts) VALUES ('rebuild')`,
+		} {
+			if _, err := db.Exec(query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasSchema reports whether the database has a table, index or trigger of
+// that name.
+func hasSchema(db *sql.DB, name string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = ?", name).Scan(&n)
+	return n > 0, err
+}
+
+// Search returns the published posts and the comments under them that match
+// q, best first. Title matches weigh more than those in the text.
+func (r *SearchRepository) Search(q module.SearchQuery) ([]module.SearchResult, error) {
+	if !r.available {
+		return nil, ErrSearchUnavailable
+	}
+	postFilter, postArgs := searchFilter(q, "p")
+	commentFilter, commentArgs := searchFilter(q, "c")
+	query := `SELECT p.id, 0, highlight(posts_fts, 0, char(2), char(3)), snippet(posts_fts, 1, char(2), char(3), '…', 24), p.author, p.date, bm25(posts_fts, 4.0, 1.0) AS rank
+		FROM posts_fts JOIN posts p ON p.id = posts_fts.rowid
+		WHERE posts_fts MATCH ? AND p.deleted_at IS NULL AND p.publish_at IS NULL` + postFilter + `
+		UNION ALL
+		SELECT p.id, c.id, p.title, snippet(comments_fts, 0, char(2), char(3), '…', 24), c.author, c.date, bm25(comments_fts) AS rank
+		FROM comments_fts JOIN comments c ON c.id = comments_fts.rowid JOIN posts p ON p.id = c.post_id
+		WHERE comments_fts MATCH ? AND p.deleted_at IS NULL AND p.publish_at IS NULL` + commentFilter + `
+		ORDER BY rank LIMIT ? OFFSET ?`
+	args := append([]interface{}{q.Match}, postArgs...)
+	args = append(args, q.Match)
+	args = append(args, commentArgs...)
+	args = append(args, q.Limit, q.Offset)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "fts5: syntax error") {
+			return nil, ErrSearchSyntax
+		}
+		log.Println("error:searchRepo:Search: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var results []module.SearchResult
+	for rows.Next() {
+		var res module.SearchResult
+		if err := rows.Scan(&res.PostID, &res.CommentID, &res.Title, &res.Snippet, &res.Author, &res.Date, &res.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// ErrSearchSyntax is returned for a match FTS5 can't parse.
+var ErrSearchSyntax = errors.New("invalid search query")
+
+// searchFilter narrows a search down to the author, tag and dates of q. table
+// is the alias of the posts or comments the results come from.
+func searchFilter(q module.SearchQuery, table string) (string, []interface{}) {
+	var b strings.Builder
+	var args []interface{}
+	if q.Author != "" {
+		b.WriteString(" AND " + table + ".author = ? COLLATE NOCASE")
+		args = append(args, q.Author)
+	}
+	if q.Tag != "" {
+		b.WriteString(" AND p.id IN (SELECT pc.post_id FROM post_categories pc JOIN categories cat ON cat.id = pc.category_id WHERE cat.slug = ?)")
+		args = append(args, q.Tag)
+	}
+	if !q.From.IsZero() {
+		b.WriteString(" AND " + table + ".ts >= ?")
+		args = append(args, q.From.Unix())
+	}
+	if !q.To.IsZero() {
+		b.WriteString(" AND " + table + ".ts < ?")
+		args = append(args, q.To.Unix())
+	}
+	return b.String(), args
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+type TwoFactor interface {
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(userID int) error
+	SetTOTPLastStep(userID int, step int64) error
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) error
+	CountRecoveryCodes(userID int) (int, error)
+	CreateLoginChallenge(c *module.LoginChallenge) error
+	GetLoginChallenge(tokenHash string) (*module.LoginChallenge, error)
+	AddLoginChallengeAttempt(id int) error
+	DeleteLoginChallenge(id int) error
+	DeleteExpiredLoginChallenges() error
+}
+
+// SetTOTPSecret stores a secret that is not enabled yet, enrollment is finished by EnableTOTP.
+func (r *AuthRepository) SetTOTPSecret(userID int, secret string) error {
+	if _, err := r.db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 0 WHERE id = ?", secret, userID); err != nil {
+		log.Println("error:authRepo:SetTOTPSecret: ", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthRepository) EnableTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		log.Println("error:authRepo:EnableTOTP: update user: ", err)
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *AuthRepository) DisableTOTP(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		log.Println("error:authRepo:DisableTOTP: update user: ", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		log.Println("error:authRepo:DisableTOTP: delete codes: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *AuthRepository) SetTOTPLastStep(userID int, step int64) error {
+	if _, err := r.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		log.Println("error:authRepo:SetTOTPLastStep: ", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthRepository) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		log.Println("error:authRepo:replaceRecoveryCodes: delete: ", err)
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			log.Println("error:authRepo:replaceRecoveryCodes: insert: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused code as used, sql.ErrNoRows means there is no such code.
+func (r *AuthRepository) UseRecoveryCode(userID int, codeHash string) error {
+	res, err := r.db.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE id = (SELECT id FROM recovery_codes WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1)",
+		time.Now(), userID, codeHash,
+	)
+	if err != nil {
+		log.Println("error:authRepo:UseRecoveryCode: ", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *AuthRepository) CountRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+func (r *AuthRepository) CreateLoginChallenge(c *module.LoginChallenge) error {
+	query := "INSERT INTO login_challenges (user_id, token_hash, remember, expires_at) VALUES (?, ?, ?, ?)"
+	if _, err := r.db.Exec(query, c.UserID, c.TokenHash, c.Remember, c.ExpiresAt); err != nil {
+		log.Println("error:authRepo:CreateLoginChallenge: ", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthRepository) GetLoginChallenge(tokenHash string) (*module.LoginChallenge, error) {
+	c := &module.LoginChallenge{}
+	err := r.db.QueryRow(
+		"SELECT id, user_id, token_hash, attempts, remember, expires_at FROM login_challenges WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&c.ID, &c.UserID, &c.TokenHash, &c.Attempts, &c.Remember, &c.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *AuthRepository) AddLoginChallengeAttempt(id int) error {
+	if _, err := r.db.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE id = ?", id); err != nil {
+		log.Println("error:authRepo:AddLoginChallengeAttempt: ", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthRepository) DeleteLoginChallenge(id int) error {
+	if _, err := r.db.Exec("DELETE FROM login_challenges WHERE id = ?", id); err != nil {
+		log.Println("error:authRepo:DeleteLoginChallenge: ", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthRepository) DeleteExpiredLoginChallenges() error {
+	if _, err := r.db.Exec("DELETE FROM login_challenges WHERE expires_at < ?", time.Now()); err != nil {
+		log.Println("error:authRepo:DeleteExpiredLoginChallenges: ", err)
+		return err
+	}
+	return nil
+}
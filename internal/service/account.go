@@ -0,0 +1,306 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+var ErrReauthFailed = errors.New("wrong password or two-factor code")
+
+// reauthenticate asks for the password again, and for a two-factor code
+// when the account has one, before an irreversible change. Wrong guesses
+// count as failed sign-ins.
+func (s *AuthService) reauthenticate(user *module.User, password, code string, client module.Client) error {
+	keys := s.loginAttemptKeys(user.Login, client)
+	if err := s.checkLoginLock(keys); err != nil {
+		return err
+	}
+	if ComparePassword(user, password) {
+		return s.guessFailed(keys, ErrReauthFailed)
+	}
+	if user.TOTPEnabled {
+		if err := s.checkSecondFactor(user, code); err != nil {
+			if errors.Is(err, ErrInvalidCode) {
+				return s.guessFailed(keys, ErrReauthFailed)
+			}
+			return err
+		}
+	}
+	s.loginSucceeded(keys)
+	return nil
+}
+
+// DeleteAccount removes the account after re-authentication. With
+// removeContent its posts, their attachments and its comments are deleted too,
+// otherwise they are kept under the name "[deleted]".
+func (s *AuthService) DeleteAccount(userID int, password, code string, removeContent bool, client module.Client) error {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:DeleteAccount: GetUserByID: ", err)
+		return err
+	}
+	if err := s.reauthenticate(user, password, code, client); err != nil {
+		return err
+	}
+	attachments, err := s.repository.DeleteUser(userID, removeContent)
+	if err != nil {
+		log.Println("Error:service:auth:DeleteAccount: ", err)
+		return err
+	}
+	removeBlobs(s.blobs, attachments)
+	if err := s.sessions.DeleteByUserID(userID, ""); err != nil {
+		log.Println("Error:service:auth:DeleteAccount: DeleteByUserID: ", err)
+		return err
+	}
+	log.Printf("service:auth:DeleteAccount: user %d deleted, content removed: %v\n", userID, removeContent)
+	return nil
+}
+
+func (s *AuthService) ExportAccount(userID int) (*module.Export, error) {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:ExportAccount: GetUserByID: ", err)
+		return nil, err
+	}
+	export := &module.Export{
+		ExportedAt: time.Now(),
+		Profile: module.ExportProfile{
+			ID:        user.ID,
+			Username:  user.Login,
+			Email:     user.Email,
+			Verified:  user.Verified,
+			Role:      user.Role,
+			TwoFactor: user.TOTPEnabled,
+			CreatedAt: user.CreatedAt,
+		},
+		Sessions:  []module.ExportSession{},
+		APITokens: []module.ExportToken{},
+	}
+	if export.Posts, err = s.repository.GetExportPosts(userID); err != nil {
+		return nil, err
+	}
+	for i := range export.Posts {
+		for j, url := range export.Posts[i].Attachments {
+			export.Posts[i].Attachments[j] = s.cfg.BaseURL + url
+		}
+	}
+	if export.Comments, err = s.repository.GetExportComments(userID); err != nil {
+		return nil, err
+	}
+	if export.Votes, err = s.repository.GetExportVotes(userID); err != nil {
+		return nil, err
+	}
+	if export.Drafts, err = s.repository.GetExportDrafts(userID); err != nil {
+		return nil, err
+	}
+	sessions, err := s.sessions.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, module.ExportSession{
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			CreatedAt: session.CreatedAt,
+			LastSeen:  session.LastSeen,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+	tokens, err := s.repository.GetAPITokensByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		t := &tokens[i]
+		et := module.ExportToken{
+			Name:      t.Name,
+			Scopes:    t.Scopes,
+			CreatedAt: t.CreatedAt,
+		}
+		if !t.ExpiresAt.IsZero() {
+			et.ExpiresAt = &t.ExpiresAt
+		}
+		if !t.LastUsed.IsZero() {
+			et.LastUsed = &t.LastUsed
+		}
+		export.APITokens = append(export.APITokens, et)
+	}
+	return export, nil
+}
+
+// checkCurrentPassword is asked before any change to the sign-in details. A
+// wrong one counts as a failed sign-in; while sign-in is locked the error is
+// returned instead of being added to verr.
+func (s *AuthService) checkCurrentPassword(user *module.User, password string, client module.Client, verr *ValidationError) error {
+	keys := s.loginAttemptKeys(user.Login, client)
+	if err := s.checkLoginLock(keys); err != nil {
+		return err
+	}
+	if ComparePassword(user, password) {
+		if err := s.guessFailed(keys, nil); err != nil {
+			return err
+		}
+		verr.add("current", ErrReauthFailed, "Current password is wrong")
+		return nil
+	}
+	s.loginSucceeded(keys)
+	return nil
+}
+
+// ChangePassword sets a new password and signs out every other session of
+// the user, keeping only currentSession.
+func (s *AuthService) ChangePassword(userID int, currentSession, current, password string, client module.Client) error {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:ChangePassword: GetUserByID: ", err)
+		return err
+	}
+	verr := &ValidationError{}
+	if err := s.checkCurrentPassword(user, current, client, verr); err != nil {
+		return err
+	}
+	checkPassword(s.cfg.Password, password, user, verr)
+	if err := verr.orNil(); err != nil {
+		return err
+	}
+	enc, err := encryptString(password)
+	if err != nil {
+		log.Println("Error:service:auth:ChangePassword: encryptString: ", err)
+		return err
+	}
+	if err := s.repository.ChangePassword(userID, enc); err != nil {
+		log.Println("Error:service:auth:ChangePassword: ", err)
+		return err
+	}
+	if err := s.sessions.DeleteByUserID(userID, currentSession); err != nil {
+		log.Println("Error:service:auth:ChangePassword: DeleteByUserID: ", err)
+		return err
+	}
+	return nil
+}
+
+// ChangeEmail mails a confirmation link to the new address. The account
+// keeps the old address until the link is opened.
+func (s *AuthService) ChangeEmail(userID int, password, email string, client module.Client) error {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:ChangeEmail: GetUserByID: ", err)
+		return err
+	}
+	verr := &ValidationError{}
+	if err := s.checkCurrentPassword(user, password, client, verr); err != nil {
+		return err
+	}
+	if err := checkEmail(email, verr); err != nil {
+		return err
+	}
+	if email == user.Email {
+		verr.add("email", ErrInvalidEmail, "This is already your email")
+	} else if _, err := s.repository.FindByEmail(email); err == nil {
+		verr.add("email", ErrEmailTaken, "An account with this email already exists")
+	}
+	if err := verr.orNil(); err != nil {
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Println("Error:service:auth:ChangeEmail: newToken: ", err)
+		return err
+	}
+	v := &module.EmailVerification{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		Email:     email,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}
+	if err := s.repository.CreateEmailVerification(v); err != nil {
+		log.Println("Error:service:auth:ChangeEmail: CreateEmailVerification: ", err)
+		return err
+	}
+	body := fmt.Sprintf("Hello, %s!\n\nPlease confirm your new email by opening the link below within %v:\n\n%s/verify?token=%s\n\n"+
+		"Until then we keep writing to your old address.\n", user.Login, emailVerificationTTL, s.cfg.BaseURL, token)
+	if err := s.mailer.Send(email, "Confirm your new email", body); err != nil {
+		log.Println("Error:service:auth:ChangeEmail: Send: ", err)
+		return err
+	}
+	notice := fmt.Sprintf("Hello, %s!\n\nSomeone asked to move your forum account to %s.\n"+
+		"If it wasn't you, change your password: the address only changes once the new one is confirmed.\n", user.Login, email)
+	if err := s.mailer.Send(user.Email, "Your forum email is being changed", notice); err != nil {
+		log.Println("Error:service:auth:ChangeEmail: Send notice: ", err)
+	}
+	return nil
+}
+
+// ChangeUsername renames the account, including the name shown on its
+// posts and comments.
+func (s *AuthService) ChangeUsername(userID int, password, login string, client module.Client) error {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:ChangeUsername: GetUserByID: ", err)
+		return err
+	}
+	verr := &ValidationError{}
+	if err := s.checkCurrentPassword(user, password, client, verr); err != nil {
+		return err
+	}
+	login = checkUsername(login, verr)
+	key := loginKey(login)
+	if login == user.Login {
+		verr.add("username", ErrInvalidUserName, "This is already your username")
+	} else if login == repository.DeletedAuthor {
+		verr.add("username", ErrInvalidUserName, "This username is already taken")
+	} else if other, err := s.repository.FindByLoginKey(key); err == nil && other.ID != userID {
+		// changing only the case or the accents of one's own name is fine
+		verr.add("username", ErrInvalidUserName, "This username is already taken")
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Error:service:auth:ChangeUsername: FindByLoginKey: ", err)
+		return err
+	}
+	if err := verr.orNil(); err != nil {
+		return err
+	}
+	if err := s.repository.ChangeUsername(userID, login, key); err != nil {
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			verr.add("username", ErrInvalidUserName, "This username is already taken")
+			return verr
+		}
+		log.Println("Error:service:auth:ChangeUsername: ", err)
+		return err
+	}
+	log.Printf("service:auth:ChangeUsername: user %d renamed from %s to %s\n", userID, user.Login, login)
+	return nil
+}
+
+// BackfillLoginKeys gives a login key to the accounts created before there
+// were any, then makes the keys unique. Existing look-alike names are
+// reported but left alone: the later account gets its key with its id
+// appended, which no name maps to, so the earlier one keeps the name.
+func (s *AuthService) BackfillLoginKeys() error {
+	users, err := s.repository.GetUsersWithoutLoginKey()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		key := loginKey(u.Login)
+		if other, err := s.repository.FindByLoginKey(key); err == nil {
+			log.Printf("service:auth:BackfillLoginKeys: %s looks like %s\n", u.Login, other.Login)
+			key = fmt.Sprintf("%s#%d", key, u.ID)
+		}
+		if err := s.repository.SetLoginKey(u.ID, key); err != nil {
+			log.Println("Error:service:auth:BackfillLoginKeys: ", err)
+			return err
+		}
+	}
+	if err := s.repository.CreateLoginKeyIndex(); err != nil {
+		log.Println("Error:service:auth:BackfillLoginKeys: CreateLoginKeyIndex: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// apiTokenPrefix makes tokens easy to recognise in scripts and secret scanners.
+const (
+	apiTokenPrefix       = "forum_"
+	apiTokenMaxName      = 64
+	apiTokenMaxValidDays = 365
+)
+
+var (
+	ErrInvalidTokenName  = errors.New("token name must be 1 to 64 characters")
+	ErrInvalidScope      = errors.New("choose at least one valid scope")
+	ErrInvalidExpiry     = errors.New("expiry must be between 0 and 365 days")
+	ErrAPITokenNotFound  = errors.New("token not found")
+	ErrInvalidAPIToken   = errors.New("invalid or expired API token")
+	ErrAPITokenForbidden = errors.New("API tokens can't be used for this request")
+)
+
+// CreateAPIToken returns the token itself; it is shown to the user once and
+// only its hash is kept. days is the lifetime, 0 means the token never expires.
+func (s *AuthService) CreateAPIToken(userID int, name string, scopes []string, days int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > apiTokenMaxName {
+		return "", ErrInvalidTokenName
+	}
+	if len(scopes) == 0 {
+		return "", ErrInvalidScope
+	}
+	seen := map[string]bool{}
+	var clean []string
+	for _, scope := range scopes {
+		if !module.ValidScope(scope) {
+			return "", ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			clean = append(clean, scope)
+		}
+	}
+	if days < 0 || days > apiTokenMaxValidDays {
+		return "", ErrInvalidExpiry
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Println("Error:service:auth:CreateAPIToken: newToken: ", err)
+		return "", err
+	}
+	token = apiTokenPrefix + token
+	now := time.Now()
+	t := &module.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scopes:    clean,
+		CreatedAt: now,
+	}
+	if days > 0 {
+		t.ExpiresAt = now.AddDate(0, 0, days)
+	}
+	if err := s.repository.CreateAPIToken(t); err != nil {
+		log.Println("Error:service:auth:CreateAPIToken: ", err)
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *AuthService) GetAPITokens(userID int) ([]module.APIToken, error) {
+	tokens, err := s.repository.GetAPITokensByUserID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:GetAPITokens: ", err)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *AuthService) RevokeAPIToken(userID, id int) error {
+	if err := s.repository.DeleteAPIToken(id, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAPITokenNotFound
+		}
+		log.Println("Error:service:auth:RevokeAPIToken: ", err)
+		return err
+	}
+	return nil
+}
+
+// AuthenticateAPIToken returns the owner of a bearer token and the token,
+// so callers can check its scopes.
+func (s *AuthService) AuthenticateAPIToken(token string) (*module.User, *module.APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	t, err := s.repository.GetAPITokenByHash(hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		log.Println("Error:service:auth:AuthenticateAPIToken: ", err)
+		return nil, nil, err
+	}
+	if t.Expired() {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	user, err := s.repository.GetUserByID(t.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if now := time.Now(); now.Sub(t.LastUsed) > sessionTouchInterval {
+		if err := s.repository.TouchAPIToken(t.ID, now); err != nil {
+			log.Println("Error:service:auth:AuthenticateAPIToken: TouchAPIToken: ", err)
+		}
+	}
+	return user, t, nil
+}
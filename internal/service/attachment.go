@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+var (
+	ErrInvalidAttachment  = errors.New("invalid attachment")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	errImageTooLarge      = errors.New("image has too many pixels")
+)
+
+// prepareUploads checks the images sent with a post and gets them ready to
+// store.
+func (s *PostService) prepareUploads(uploads []module.Upload) ([]*preparedImage, error) {
+	verr := &ValidationError{}
+	if len(uploads) > s.uploads.MaxFiles {
+		msg := fmt.Sprintf("A post can have at most %d images", s.uploads.MaxFiles)
+		if s.uploads.MaxFiles == 0 {
+			msg = "Images can't be attached to posts"
+		}
+		verr.add("attachments", ErrInvalidAttachment, msg)
+		return nil, verr
+	}
+	var images []*preparedImage
+	for i, upload := range uploads {
+		if int64(len(upload.Data)) > s.uploads.MaxBytes {
+			verr.add("attachments", ErrInvalidAttachment, fmt.Sprintf("Image %d is larger than %d KB", i+1, s.uploads.MaxBytes>>10))
+			continue
+		}
+		img, err := prepareImage(upload.Data)
+		switch {
+		case errors.Is(err, errImageTooLarge):
+			verr.add("attachments", ErrInvalidAttachment, fmt.Sprintf("Image %d is larger than %d megapixels", i+1, maxImagePixels/1000000))
+		case errors.Is(err, ErrInvalidAttachment):
+			verr.add("attachments", ErrInvalidAttachment, fmt.Sprintf("Image %d is not a JPEG, PNG, GIF or WebP image", i+1))
+		case err != nil:
+			log.Println("error:service:post:prepareUploads: ", err)
+			return nil, err
+		default:
+			images = append(images, img)
+		}
+	}
+	return images, verr.orNil()
+}
+
+// storeImages puts the images and their thumbnails in the blob store under
+// new random keys. If one fails, those already stored are removed.
+func (s *PostService) storeImages(images []*preparedImage) ([]module.Attachment, error) {
+	var attachments []module.Attachment
+	for _, img := range images {
+		token, err := newToken()
+		if err != nil {
+			removeBlobs(s.blobs, attachments)
+			return nil, err
+		}
+		key := token[:2] + "/" + token
+		a := module.Attachment{
+			Key:         key + imageFormats[img.contentType].ext,
+			ContentType: img.contentType,
+			Size:        int64(len(img.data)),
+			Width:       img.width,
+			Height:      img.height,
+			ThumbKey:    key + "-thumb" + imageFormats[img.thumbType].ext,
+			ThumbType:   img.thumbType,
+		}
+		if err := s.blobs.Put(a.Key, bytes.NewReader(img.data)); err != nil {
+			removeBlobs(s.blobs, attachments)
+			return nil, err
+		}
+		// added before the thumbnail is stored, so that a failure removes the image too
+		attachments = append(attachments, a)
+		if err := s.blobs.Put(a.ThumbKey, bytes.NewReader(img.thumb)); err != nil {
+			removeBlobs(s.blobs, attachments)
+			return nil, err
+		}
+	}
+	return attachments, nil
+}
+
+// OpenAttachment returns an attachment with its image, or its thumbnail when
+// thumb is set, if actor may see the post. The caller closes the reader.
+func (s *PostService) OpenAttachment(actor *module.User, id int, thumb bool) (*module.Attachment, io.ReadSeekCloser, error) {
+	a, err := s.repository.GetAttachment(id)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:OpenAttachment: ", err)
+		return nil, nil, err
+	}
+	post, err := s.repository.GetPostByPostId(a.PostID)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:OpenAttachment: ", err)
+		return nil, nil, err
+	}
+	if !post.VisibleTo(actor) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	key := a.Key
+	if thumb {
+		key = a.ThumbKey
+	}
+	blob, err := s.blobs.Open(key)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("error:service:post:OpenAttachment: blob %s of attachment %d is missing\n", key, id)
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:OpenAttachment: ", err)
+		return nil, nil, err
+	}
+	return a, blob, nil
+}
+
+// removeBlobs deletes the images of attachments that are gone from the
+// database. A blob that can't be deleted is only logged: the attachment
+// can't be reached anymore either way.
+func removeBlobs(blobs BlobStore, attachments []module.Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.Key, a.ThumbKey} {
+			if err := blobs.Delete(key); err != nil {
+				log.Printf("error:service:removeBlobs: %s: %v\n", key, err)
+			}
+		}
+	}
+}
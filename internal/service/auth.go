@@ -1,13 +1,19 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"regexp"
 	"time"
+	"unicode"
 
+	"github.com/ive663/forum/internal/config"
+	"github.com/ive663/forum/internal/mailer"
 	"github.com/ive663/forum/internal/module"
 	"github.com/ive663/forum/internal/repository"
 
@@ -20,25 +26,83 @@ var (
 	ErrInvalidUserName = errors.New("invalid username")
 	ErrInvalidEmail    = errors.New("invalid email")
 	ErrInvalidPassword = errors.New("invalid password")
+	ErrInvalidToken    = errors.New("link is invalid or has expired")
+	ErrNotVerified     = errors.New("please confirm your email first")
+	ErrAlreadyVerified = errors.New("email is already confirmed")
+	ErrSessionNotFound = errors.New("session not found")
+	ErrEmailTaken      = errors.New("an account with this email already exists")
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
 )
 
 type Auth interface {
 	CreateNewUser(user *module.User) (newUser *module.User, err error)
-	GenerateSessionToken(login, password string) (string, error)
+	GenerateSessionToken(login, password string, remember bool, client module.Client) (string, error)
 	ParseSessionToken(token string) (*module.User, error)
 	DeleteSessionToken(token string) error
 	GetUserIdByUUID(token string) (int, error)
+	GetSession(token string) (*module.Session, error)
+	RotateSession(token string) (string, error)
 	GetUserByUserID(id int) (*module.User, error)
 	DeleteExpiredSessions() error
+	RequestPasswordReset(email string) error
+	DeleteExpiredPasswordResets() error
+	CheckPasswordResetToken(token string) error
+	ResetPassword(token, password string) error
+	SendVerification(user *module.User) error
+	VerifyEmail(token string) error
+	DeleteUnverifiedUsers() error
+	GetSessions(userID int, currentToken string) ([]module.Session, error)
+	RevokeSession(userID, sessionID int) error
+	RevokeAllSessions(userID int) error
+	CompleteSignIn(challenge, code string, client module.Client) (token string, remember bool, err error)
+	MustEnrollTwoFactor(user *module.User) bool
+	GetTwoFactor(userID int) (*module.TwoFactor, error)
+	BeginTOTPEnrollment(userID int) (*module.TwoFactor, error)
+	ConfirmTOTPEnrollment(userID int, code string) ([]string, error)
+	DisableTOTP(userID int, password, code string, client module.Client) error
+	RegenerateRecoveryCodes(userID int, code string, client module.Client) ([]string, error)
+	DeleteExpiredLoginChallenges() error
+	GetLoginLocks(actor *module.User) ([]module.LoginAttempt, error)
+	ClearLoginLock(actor *module.User, key string) error
+	SeedAdmins() error
+	GetUsers(actor *module.User) ([]module.User, error)
+	SetUserRole(actor *module.User, userID int, role string) error
+	CreateAPIToken(userID int, name string, scopes []string, days int) (string, error)
+	GetAPITokens(userID int) ([]module.APIToken, error)
+	RevokeAPIToken(userID, id int) error
+	AuthenticateAPIToken(token string) (*module.User, *module.APIToken, error)
+	DeleteAccount(userID int, password, code string, removeContent bool, client module.Client) error
+	ExportAccount(userID int) (*module.Export, error)
+	ChangePassword(userID int, currentSession, current, password string, client module.Client) error
+	ChangeEmail(userID int, password, email string, client module.Client) error
+	ChangeUsername(userID int, password, login string, client module.Client) error
+	DeleteStaleLoginAttempts() error
+	SessionCacheStats() (SessionCacheStats, bool)
+	BackfillLoginKeys() error
 }
 
+// last-seen of a session is written at most once per sessionTouchInterval
+const sessionTouchInterval = time.Minute
+
 type AuthService struct {
 	repository repository.Auth
+	sessions   SessionStore
+	mailer     mailer.Mailer
+	blobs      BlobStore
+	cfg        *config.Config
 }
 
-func newAuthService(repository repository.Auth) *AuthService {
+func newAuthService(repository repository.Auth, mailer mailer.Mailer, blobs BlobStore, cfg *config.Config) *AuthService {
 	return &AuthService{
 		repository: repository,
+		sessions:   newSessionStore(cfg.Sessions, repository),
+		mailer:     mailer,
+		blobs:      blobs,
+		cfg:        cfg,
 	}
 }
 
@@ -71,14 +135,37 @@ func ComparePassword(u *module.User, password string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(u.EncryptedPassword), []byte(password)) != nil
 }
 
-func validUser(u *module.User) error {
-	for _, char := range u.Login {
-		if char < 32 || char > 127 {
+func validUser(u *module.User, policy config.Password) error {
+	verr := &ValidationError{}
+	u.Login = checkUsername(u.Login, verr)
+	if err := checkEmail(u.Email, verr); err != nil {
+		return err
+	}
+	checkPassword(policy, u.Password, u, verr)
+	return verr.orNil()
+}
+
+// checkUsername returns login normalized. Names may use letters of any
+// alphabet, but not mix Latin, Cyrillic and Greek ones.
+func checkUsername(login string, verr *ValidationError) string {
+	login = checkText(login, textRule{
+		field: "username", label: "Username", min: minUsernameRunes, max: maxUsernameRunes, err: ErrInvalidUserName,
+	}, verr)
+	for _, r := range login {
+		if !unicode.In(r, unicode.L, unicode.M, unicode.Nd) && r != '_' && r != '-' && r != '.' {
 			log.Println("Error:service:auth:validUser: invalid username")
-			return ErrInvalidUserName
+			verr.add("username", ErrInvalidUserName, "Username may only contain letters, digits, '_', '-' and '.'")
+			return login
 		}
 	}
-	validEmail, err := regexp.MatchString(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`, u.Email)
+	if mixesScripts(login) {
+		verr.add("username", ErrInvalidUserName, "Username can't mix Latin, Cyrillic and Greek letters")
+	}
+	return login
+}
+
+func checkEmail(email string, verr *ValidationError) error {
+	validEmail, err := regexp.MatchString(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`, email)
 	// validEmail, err := mail.ParseAddress(u.Email)
 	if err != nil {
 		log.Println("Error:service:auth:validUser: ParseAddress")
@@ -86,91 +173,117 @@ func validUser(u *module.User) error {
 	}
 	if !validEmail {
 		log.Println("Error:service:auth:validUser: invalid email")
-		return ErrInvalidEmail
-	}
-	if len(u.Login) < 4 || len(u.Login) > 36 {
-		log.Println("Error:service:auth:validUser: invalid login")
-		return ErrInvalidUserName
+		verr.add("email", ErrInvalidEmail, "Please... use jon@smith.com format")
 	}
 	return nil
 }
 
-func (s *AuthService) GenerateSessionToken(username, password string) (string, error) {
-	log.Println("service:auth:GenerateSession: password: ", password)
+func (s *AuthService) GenerateSessionToken(username, password string, remember bool, client module.Client) (string, error) {
+	username = normalize(username)
+	keys := s.loginAttemptKeys(username, client)
+	if err := s.checkLoginLock(keys); err != nil {
+		return "", err
+	}
 	user, err := s.repository.FindByLogin(username)
 	if err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			log.Println("Error:service:auth:GenerateSessionToken: FindByLogin")
-			return "", ErrUserNotFound
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Println("Error:service:auth:GenerateSessionToken: FindByLogin: no user ", username)
+			return "", s.loginFailed(keys)
 		}
 		log.Println("Error:service:auth:GenerateSessionToken: FindByLogin: ", err)
 		return "", err
 	}
 	if ComparePassword(user, password) {
-		log.Println(user, password)
-		log.Println("Error:service:auth:GenerateSessionToken: ComparePassword: ", err)
-		return "", ErrUserNotFound
+		log.Println("Error:service:auth:GenerateSessionToken: ComparePassword: wrong password for ", user.Login)
+		return "", s.loginFailed(keys)
+	}
+	if user.TOTPEnabled {
+		// the failures are forgotten only once the code is right too
+		challenge, err := s.newLoginChallenge(user.ID, remember)
+		if err != nil {
+			log.Println("Error:service:auth:GenerateSessionToken: newLoginChallenge: ", err)
+			return "", err
+		}
+		return "", &ChallengeError{Token: challenge}
 	}
+	s.loginSucceeded(keys)
+	return s.createSession(user.ID, remember, client)
+}
+
+// createSession starts a new session for the user. Sessions opened on other
+// devices are kept, they can be revoked from the sessions page.
+func (s *AuthService) createSession(userID int, remember bool, client module.Client) (string, error) {
 	token := uuid.NewV4()
-	fmt.Println("TOKEN: " + token.String())
+	now := time.Now()
 	session := &module.Session{
-		UserID:    user.ID,
+		UserID:    userID,
 		UUID:      token.String(),
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(12 * time.Hour),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL(remember)),
+		LastSeen:  now,
+		Remember:  remember,
 	}
-	isSessionExists, err := s.repository.IsSessionExists(user.ID)
-	if err != nil {
-		log.Println("Error:service:auth: IsSessionExists: ", err)
+	if err := s.sessions.Create(session); err != nil {
+		log.Println("Error:Service:Auth: CreateNewSession: ", err)
 		return "", err
 	}
-
-	if isSessionExists {
-		log.Println("service:GenSessionToken: updating session token")
-		err = s.repository.UpdateSession(session)
-		if err != nil {
-			log.Println("Error:service:auth: updateSession: ", err)
-			return "", err
-		}
-	} else {
-		err = s.repository.CreateNewSession(session)
-		if err != nil {
-			log.Println("Error:Service:Auth: CreateNewSession: ", err)
-			return "", err
-		}
-	}
 	return token.String(), nil
 }
 
 func (s *AuthService) CreateNewUser(user *module.User) (*module.User, error) {
-	err := validUser(user)
+	err := validUser(user, s.cfg.Password)
 	if err != nil {
 		log.Println("Error:service:auth:CreateNewUser validUser: ", err)
 		return nil, err
 	}
+	verr := &ValidationError{}
+	user.LoginKey = loginKey(user.Login)
+	if _, err := s.repository.FindByLoginKey(user.LoginKey); err == nil {
+		verr.add("username", ErrInvalidUserName, "This username is already taken")
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Error:service:auth:CreateNewUser: FindByLoginKey: ", err)
+		return nil, err
+	}
+	if _, err := s.repository.FindByEmail(user.Email); err == nil {
+		verr.add("email", ErrInvalidEmail, "An account with this email already exists")
+	}
+	if err := verr.orNil(); err != nil {
+		return nil, err
+	}
+	user.Verified = false
+	user.CreatedAt = time.Now()
 	user.EncryptedPassword, err = encryptString(user.Password)
-	log.Println("service:auth:CreateNewUser: encrypted password: ", user.EncryptedPassword)
 	if err != nil {
 		log.Println("error:service:auth:CreateNewUser: encrypted password: ", err)
 		return nil, err
 	}
 	err = s.repository.CreateNewUser(user)
-	log.Println("service:auth:CreateNewUser: create new user: ", user)
+	log.Println("service:auth:CreateNewUser: create new user: ", user.Login)
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		verr.add("username", ErrInvalidUserName, "This username is already taken")
+		return nil, verr
+	}
 	if err != nil {
 		log.Println("error:service:auth:CreateNewUser: create new user: ", err)
 		return nil, err
 	}
 	newUser, err := s.repository.FindByLogin(user.Login)
-	log.Println("service:auth:CreateNewUser: find by login: ", newUser)
 	if err != nil {
 		log.Println("Error:service:auth:CreateNewUser: FindByLogin: ", err)
 		return nil, err
 	}
+	newUser.Email = user.Email
+	// the account is already created, the letter can be requested again from the index page
+	if err := s.SendVerification(newUser); err != nil {
+		log.Println("Error:service:auth:CreateNewUser: SendVerification: ", err)
+	}
 	return newUser, nil
 }
 
 func (s *AuthService) ParseSessionToken(token string) (*module.User, error) {
-	uid, err := s.repository.GetUserIdByUUID(token)
+	uid, err := s.GetUserIdByUUID(token)
 	if err != nil {
 		log.Println("Error:service:auth:ParseSessionToken: GetUUIDFindID")
 		return nil, err
@@ -183,15 +296,60 @@ func (s *AuthService) ParseSessionToken(token string) (*module.User, error) {
 	return user, nil
 }
 
+func (s *AuthService) sessionTTL(remember bool) time.Duration {
+	if remember {
+		return s.cfg.Sessions.RememberTTL
+	}
+	return s.cfg.Sessions.TTL
+}
+
 func (s *AuthService) GetUserIdByUUID(token string) (int, error) {
+	session, err := s.GetSession(token)
+	if err != nil {
+		return 0, err
+	}
+	return session.UserID, nil
+}
+
+// GetSession returns the live session behind token. Sessions expire after a
+// while without requests: a request moves the expiry forward, and the
+// session comes back Renewed when that was written down.
+func (s *AuthService) GetSession(token string) (*module.Session, error) {
 	if token == "" {
-		return 0, ErrEmptyValue
+		return nil, ErrEmptyValue
 	}
-	uid, err := s.repository.GetUserIdByUUID(token)
+	session, err := s.sessions.Get(token)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		return nil, ErrUserNotFound
+	}
+	if now.Sub(session.LastSeen) > sessionTouchInterval {
+		session.LastSeen = now
+		session.ExpiresAt = now.Add(s.sessionTTL(session.Remember))
+		if err := s.sessions.Touch(session); err != nil {
+			log.Println("Error:service:auth:GetSession: TouchSession: ", err)
+		} else {
+			session.Renewed = true
+		}
+	}
+	return session, nil
+}
+
+// RotateSession moves the session to a new token after the user's
+// privileges changed, so a token leaked before the change is worthless.
+func (s *AuthService) RotateSession(token string) (string, error) {
+	newToken := uuid.NewV4().String()
+	if err := s.sessions.Rotate(token, newToken); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrSessionNotFound
+		}
+		log.Println("Error:service:auth:RotateSession: ", err)
+		return "", err
 	}
-	return uid, nil
+	return newToken, nil
 }
 
 func (s *AuthService) GetUserByUserID(id int) (*module.User, error) {
@@ -208,7 +366,7 @@ func (s *AuthService) GetUserByUserID(id int) (*module.User, error) {
 }
 
 func (s *AuthService) DeleteSessionToken(token string) error {
-	err := s.repository.Delete(token)
+	err := s.sessions.Delete(token)
 	if err != nil {
 		log.Println("Error:service:auth:DeleteSessionToken: Delete")
 		return err
@@ -217,10 +375,250 @@ func (s *AuthService) DeleteSessionToken(token string) error {
 }
 
 func (s *AuthService) DeleteExpiredSessions() error {
-	err := s.repository.DeleteExpiredSession()
+	err := s.sessions.DeleteExpired(time.Now())
 	if err != nil {
 		log.Println("Error:service:auth:DeleteExpiredSessions: DeleteExpiredSession")
 		return err
 	}
 	return nil
 }
+
+// RequestPasswordReset mails a single-use reset link to the owner of email.
+// Unknown addresses are not reported so the form can't be used to probe accounts.
+func (s *AuthService) RequestPasswordReset(email string) error {
+	user, err := s.repository.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Println("service:auth:RequestPasswordReset: unknown email")
+			return nil
+		}
+		log.Println("Error:service:auth:RequestPasswordReset: FindByEmail: ", err)
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Println("Error:service:auth:RequestPasswordReset: newToken: ", err)
+		return err
+	}
+	reset := &module.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	if err := s.repository.CreatePasswordReset(reset); err != nil {
+		log.Println("Error:service:auth:RequestPasswordReset: CreatePasswordReset: ", err)
+		return err
+	}
+	body := fmt.Sprintf("Hello, %s!\n\nSomeone asked to reset the password of your forum account.\n"+
+		"Open the link below within %v to choose a new one:\n\n%s/reset?token=%s\n\n"+
+		"If it wasn't you, just ignore this letter.\n", user.Login, passwordResetTTL, s.cfg.BaseURL, token)
+	if err := s.mailer.Send(user.Email, "Forum password reset", body); err != nil {
+		log.Println("Error:service:auth:RequestPasswordReset: Send: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) CheckPasswordResetToken(token string) error {
+	_, err := s.findPasswordReset(token)
+	return err
+}
+
+func (s *AuthService) ResetPassword(token, password string) error {
+	reset, err := s.findPasswordReset(token)
+	if err != nil {
+		return err
+	}
+	user, err := s.repository.GetUserByID(reset.UserID)
+	if err != nil {
+		log.Println("Error:service:auth:ResetPassword: GetUserByID: ", err)
+		return err
+	}
+	verr := &ValidationError{}
+	if checkPassword(s.cfg.Password, password, user, verr); verr.orNil() != nil {
+		return verr
+	}
+	enc, err := encryptString(password)
+	if err != nil {
+		log.Println("Error:service:auth:ResetPassword: encryptString: ", err)
+		return err
+	}
+	if err := s.repository.ResetPassword(reset, enc); err != nil {
+		if errors.Is(err, repository.ErrResetTokenUsed) {
+			return ErrInvalidToken
+		}
+		log.Println("Error:service:auth:ResetPassword: ResetPassword: ", err)
+		return err
+	}
+	if err := s.sessions.DeleteByUserID(reset.UserID, ""); err != nil {
+		log.Println("Error:service:auth:ResetPassword: DeleteByUserID: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) findPasswordReset(token string) (*module.PasswordReset, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	reset, err := s.repository.GetPasswordReset(hashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		log.Println("Error:service:auth:findPasswordReset: GetPasswordReset: ", err)
+		return nil, err
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	return reset, nil
+}
+
+func (s *AuthService) DeleteExpiredLoginChallenges() error {
+	if err := s.repository.DeleteExpiredLoginChallenges(); err != nil {
+		log.Println("Error:service:auth:DeleteExpiredLoginChallenges: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) DeleteExpiredPasswordResets() error {
+	if err := s.repository.DeleteExpiredPasswordResets(); err != nil {
+		log.Println("Error:service:auth:DeleteExpiredPasswordResets: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) SendVerification(user *module.User) error {
+	if user.Verified {
+		return ErrAlreadyVerified
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Println("Error:service:auth:SendVerification: newToken: ", err)
+		return err
+	}
+	v := &module.EmailVerification{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}
+	if err := s.repository.CreateEmailVerification(v); err != nil {
+		log.Println("Error:service:auth:SendVerification: CreateEmailVerification: ", err)
+		return err
+	}
+	body := fmt.Sprintf("Hello, %s!\n\nPlease confirm your email by opening the link below within %v:\n\n%s/verify?token=%s\n\n"+
+		"Until then you can read the forum, but not post, comment or vote.\n"+
+		"Accounts that are not confirmed in %d days are deleted.\n", user.Login, emailVerificationTTL, s.cfg.BaseURL, token, int(s.cfg.UnverifiedTTL.Hours()/24))
+	if err := s.mailer.Send(user.Email, "Confirm your forum account", body); err != nil {
+		log.Println("Error:service:auth:SendVerification: Send: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) VerifyEmail(token string) error {
+	if token == "" {
+		return ErrInvalidToken
+	}
+	v, err := s.repository.GetEmailVerification(hashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidToken
+		}
+		log.Println("Error:service:auth:VerifyEmail: GetEmailVerification: ", err)
+		return err
+	}
+	if time.Now().After(v.ExpiresAt) {
+		return ErrInvalidToken
+	}
+	if v.Email != "" {
+		if other, err := s.repository.FindByEmail(v.Email); err == nil && other.ID != v.UserID {
+			return ErrEmailTaken
+		}
+	}
+	if err := s.repository.VerifyUser(v); err != nil {
+		log.Println("Error:service:auth:VerifyEmail: VerifyUser: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) DeleteUnverifiedUsers() error {
+	ids, err := s.repository.DeleteUnverifiedUsers(time.Now().Add(-s.cfg.UnverifiedTTL))
+	if err != nil {
+		log.Println("Error:service:auth:DeleteUnverifiedUsers: ", err)
+		return err
+	}
+	for _, id := range ids {
+		if err := s.sessions.DeleteByUserID(id, ""); err != nil {
+			log.Println("Error:service:auth:DeleteUnverifiedUsers: DeleteByUserID: ", err)
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		log.Println("service:auth:DeleteUnverifiedUsers: deleted ", len(ids))
+	}
+	return nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken is used for tokens that are handed out by mail: only the hash is stored,
+// so a leaked database can't be used to take over accounts.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetSessions lists the sessions of the user, marking the one that belongs to currentToken.
+func (s *AuthService) GetSessions(userID int, currentToken string) ([]module.Session, error) {
+	sessions, err := s.sessions.ListByUserID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:GetSessions: ", err)
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].UUID == currentToken
+	}
+	return sessions, nil
+}
+
+func (s *AuthService) RevokeSession(userID, sessionID int) error {
+	if err := s.sessions.DeleteByID(sessionID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionNotFound
+		}
+		log.Println("Error:service:auth:RevokeSession: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) RevokeAllSessions(userID int) error {
+	if err := s.sessions.DeleteByUserID(userID, ""); err != nil {
+		log.Println("Error:service:auth:RevokeAllSessions: ", err)
+		return err
+	}
+	return nil
+}
+
+// SessionCacheStats reports the session cache counters, or false when the
+// cache is turned off.
+func (s *AuthService) SessionCacheStats() (SessionCacheStats, bool) {
+	cache, ok := s.sessions.(*SessionCache)
+	if !ok {
+		return SessionCacheStats{}, false
+	}
+	return cache.Stats(), true
+}
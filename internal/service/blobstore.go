@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ive663/forum/internal/config"
+)
+
+// BlobStore keeps uploaded files under keys the forum makes up. Keys are
+// slash-separated paths. A missing blob is reported as os.ErrNotExist,
+// whatever the store is.
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadSeekCloser, error)
+	// Delete removes a blob; removing a missing one is not an error.
+	Delete(key string) error
+}
+
+func newBlobStore(cfg config.Uploads) BlobStore {
+	switch cfg.Store {
+	case "local":
+		return NewLocalBlobStore(cfg.Dir)
+	default:
+		log.Printf("Error:service:newBlobStore: unknown store %q, using local\n", cfg.Store)
+		return NewLocalBlobStore(cfg.Dir)
+	}
+}
+
+// LocalBlobStore keeps blobs as files under a directory of the local disk.
+type LocalBlobStore struct {
+	dir string
+}
+
+func NewLocalBlobStore(dir string) *LocalBlobStore {
+	return &LocalBlobStore{dir: dir}
+}
+
+var errInvalidBlobKey = errors.New("invalid blob key")
+
+// path maps key to a file under the store directory. Keys that would lead
+// out of it are refused.
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if key == "" || path.Clean(key) != key || path.IsAbs(key) || key == ".." || strings.HasPrefix(key, "../") || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("%w: %q", errInvalidBlobKey, key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first, so that a failed upload
+// never leaves half a file under the key.
+func (s *LocalBlobStore) Put(key string, r io.Reader) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (s *LocalBlobStore) Open(key string) (io.ReadSeekCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrInvalidCategory  = errors.New("invalid category")
+	ErrCategoryExists   = errors.New("A category with this name or slug already exists")
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type Categories interface {
+	GetCatalogue() ([]module.Category, error)
+	GetCategories() ([]module.Category, error)
+	GetCategory(slug string) (*module.Category, error)
+	CreateCategory(actor *module.User, c *module.Category) error
+	UpdateCategory(actor *module.User, c *module.Category) error
+	DeleteCategory(actor *module.User, id int) error
+}
+
+type CategoryService struct {
+	repository repository.Categories
+}
+
+func newCategoryService(repository repository.Categories) *CategoryService {
+	return &CategoryService{
+		repository: repository,
+	}
+}
+
+// GetCatalogue returns the categories posts can be put in, for forms to
+// pick from.
+func (s *CategoryService) GetCatalogue() ([]module.Category, error) {
+	categories, err := s.repository.GetCatalogue()
+	if err != nil {
+		log.Println("error:service:category:GetCatalogue: ", err)
+		return nil, err
+	}
+	return categories, nil
+}
+
+// GetCategories returns the catalogue with the number of posts in each
+// category and when they were last active.
+func (s *CategoryService) GetCategories() ([]module.Category, error) {
+	categories, err := s.repository.GetCategories()
+	if err != nil {
+		log.Println("error:service:category:GetCategories: ", err)
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (s *CategoryService) GetCategory(slug string) (*module.Category, error) {
+	c, err := s.repository.GetCategoryBySlug(slug)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		log.Println("error:service:category:GetCategory: ", err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateCategory adds a category to the catalogue; only administrators
+// keep it. Without a slug, one is made from the name.
+func (s *CategoryService) CreateCategory(actor *module.User, c *module.Category) error {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return err
+	}
+	if err := validCategory(c); err != nil {
+		return err
+	}
+	if err := s.repository.CreateCategory(c); err != nil {
+		if errors.Is(err, repository.ErrCategoryExists) {
+			return ErrCategoryExists
+		}
+		log.Println("error:service:category:CreateCategory: ", err)
+		return err
+	}
+	log.Printf("service:category:CreateCategory: %s added %q\n", actor.Login, c.Slug)
+	return nil
+}
+
+// UpdateCategory changes a category. Changing the slug changes the address
+// of its page.
+func (s *CategoryService) UpdateCategory(actor *module.User, c *module.Category) error {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return err
+	}
+	if err := validCategory(c); err != nil {
+		return err
+	}
+	err := s.repository.UpdateCategory(c)
+	switch {
+	case errors.Is(err, repository.ErrCategoryExists):
+		return ErrCategoryExists
+	case errors.Is(err, repository.ErrRecordNotFound):
+		return ErrCategoryNotFound
+	case err != nil:
+		log.Println("error:service:category:UpdateCategory: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteCategory removes a category; its posts stay, without it.
+func (s *CategoryService) DeleteCategory(actor *module.User, id int) error {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return err
+	}
+	err := s.repository.DeleteCategory(id)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return ErrCategoryNotFound
+	}
+	if err != nil {
+		log.Println("error:service:category:DeleteCategory: ", err)
+		return err
+	}
+	log.Printf("service:category:DeleteCategory: %s deleted category %d\n", actor.Login, id)
+	return nil
+}
+
+// validCategory checks a category and normalizes it in place.
+func validCategory(c *module.Category) error {
+	verr := &ValidationError{}
+	c.Name = strings.TrimSpace(checkText(c.Name, textRule{
+		field: "name", label: "Name", min: 1, max: maxCategoryRunes, err: ErrInvalidCategory,
+	}, verr))
+	c.Slug = strings.TrimSpace(c.Slug)
+	if c.Slug == "" {
+		c.Slug = module.Slugify(c.Name)
+	}
+	switch {
+	case c.Slug == "" && c.Name != "":
+		verr.add("slug", ErrInvalidCategory, "The name has no letters or digits, give the category a slug")
+	case c.Slug != module.Slugify(c.Slug):
+		verr.add("slug", ErrInvalidCategory, "Slug may only have lower case letters, digits and dashes between them")
+	case utf8.RuneCountInString(c.Slug) > maxCategoryRunes:
+		verr.add("slug", ErrInvalidCategory, fmt.Sprintf("Slug must be at most %d characters long", maxCategoryRunes))
+	}
+	if strings.TrimSpace(c.Description) != "" {
+		c.Description = strings.TrimSpace(checkText(c.Description, textRule{
+			field: "description", label: "Description", min: 1, max: maxDescriptionRunes, err: ErrInvalidCategory,
+		}, verr))
+	} else {
+		c.Description = ""
+	}
+	if c.Color == "" {
+		c.Color = module.DefaultCategoryColor
+	}
+	if !colorPattern.MatchString(c.Color) {
+		verr.add("color", ErrInvalidCategory, "Color must be given as #rrggbb")
+	}
+	return verr.orNil()
+}
+
+// checkCategories picks the categories of a post from the catalogue. They
+// may be given by slug or by name. A post needs one if there are any.
+func checkCategories(picked []string, catalogue []module.Category, verr *ValidationError) []string {
+	known := make(map[string]bool)
+	for _, c := range catalogue {
+		known[c.Slug] = true
+	}
+	var slugs []string
+	for _, v := range picked {
+		slug := module.Slugify(normalize(v))
+		if !known[slug] {
+			verr.add("categories", ErrInvalidTypingCategory, "Pick the categories from the list")
+			return nil
+		}
+		if !contains(slugs, slug) {
+			slugs = append(slugs, slug)
+		}
+	}
+	if len(slugs) == 0 && len(catalogue) > 0 {
+		verr.add("categories", ErrInvalidTypingCategory, "Pick at least one category")
+	}
+	if len(slugs) > maxPostCategories {
+		verr.add("categories", ErrInvalidTypingCategory, fmt.Sprintf("A post can have at most %d categories", maxPostCategories))
+	}
+	return slugs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
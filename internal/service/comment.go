@@ -9,10 +9,13 @@ import (
 	"github.com/ive663/forum/internal/repository"
 )
 
-var ErrInvalidComment = errors.New("Invalid typing comment")
+var (
+	ErrInvalidComment  = errors.New("Invalid typing comment")
+	ErrCommentNotFound = errors.New("comment not found")
+)
 
 type Comment interface {
-	GetComments(postId int) (module.CommentList, error)
+	GetComments(postId int, q module.PageQuery) (module.CommentList, module.Pagination, error)
 	CreateComment(comment *module.Comment) error
 	///  added new interfaces for likes and dislikes ///
 	GetCommentLikesByPostID(postID int) (map[int][]int, error)
@@ -20,6 +23,8 @@ type Comment interface {
 	AddLikeByComment(commentID int, userID int) error
 	AddDislikeByComment(commentID int, userID int) error
 	GetPostIdByCommentId(commentID int) (*module.Comment, error)
+	GetCommentVote(commentID int, userID int) (*module.Vote, error)
+	DeleteComment(actor *module.User, commentID int) (*module.Comment, error)
 }
 
 type CommentService struct {
@@ -107,13 +112,15 @@ func (s *CommentService) AddDislikeByComment(commentID int, userID int) error {
 
 ///=======================================///
 
-func (s *CommentService) GetComments(postId int) (module.CommentList, error) {
-	comments, err := s.repository.FindCommentsInPostID(postId)
+func (s *CommentService) GetComments(postId int, q module.PageQuery) (module.CommentList, module.Pagination, error) {
+	q, size := pageQuery(q)
+	comments, err := s.repository.FindCommentsInPostID(postId, q)
 	if err != nil {
 		log.Println("error:service:comment: GetComments")
-		return nil, err
+		return nil, module.Pagination{}, err
 	}
-	return comments, nil
+	comments, page := pageOf(comments, q, size, commentCursor)
+	return comments, page, nil
 }
 
 func (s *CommentService) CreateComment(comment *module.Comment) error {
@@ -130,6 +137,9 @@ func (s *CommentService) CreateComment(comment *module.Comment) error {
 
 func (s *CommentService) GetPostIdByCommentId(commentID int) (*module.Comment, error) {
 	c, err := s.repository.GetPostIdByCommentId(commentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
 	if err != nil {
 		log.Println("error:service:comment: GetPostIdByCommentId")
 		return nil, err
@@ -138,20 +148,11 @@ func (s *CommentService) GetPostIdByCommentId(commentID int) (*module.Comment, e
 }
 
 func ValidComment(comment *module.Comment) error {
-	onlyspace := true
-	for _, check := range comment.Message {
-		if check < 32 || check > 127 {
-			return ErrInvalidComment
-		}
-		if check != ' ' {
-			onlyspace = false
-			break
-		}
-	}
-	if onlyspace {
-		return ErrEmptyValue
-	}
-	return nil
+	verr := &ValidationError{}
+	comment.Message = checkText(comment.Message, textRule{
+		field: "comment", label: "Comment", min: 1, max: maxCommentRunes, multiline: true, err: ErrInvalidComment,
+	}, verr)
+	return verr.orNil()
 }
 
 func GetCommentId(comments []module.Comment) int {
@@ -160,3 +161,40 @@ func GetCommentId(comments []module.Comment) int {
 	}
 	return 0
 }
+
+// GetCommentVote returns the counters of a comment and the vote userID gave it.
+func (s *CommentService) GetCommentVote(commentID int, userID int) (*module.Vote, error) {
+	vote, err := s.repository.GetCommentVote(commentID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		log.Println("error:service:comment:GetCommentVote: ", err)
+		return nil, err
+	}
+	return vote, nil
+}
+
+// DeleteComment lets authors remove their own comments and moderators remove
+// anyone's. The deleted comment is returned so callers know its post.
+func (s *CommentService) DeleteComment(actor *module.User, commentID int) (*module.Comment, error) {
+	c, err := s.repository.GetCommentByID(commentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		log.Println("error:service:comment:DeleteComment: GetCommentByID: ", err)
+		return nil, err
+	}
+	if actor == nil || (actor.ID != c.AuthorID && !actor.HasRole(module.RoleModerator)) {
+		return nil, ErrForbidden
+	}
+	if err := s.repository.DeleteComment(commentID); err != nil {
+		log.Println("error:service:comment:DeleteComment: ", err)
+		return nil, err
+	}
+	if actor.ID != c.AuthorID {
+		log.Printf("service:comment:DeleteComment: %s removed comment %d by %s\n", actor.Login, c.ID, c.Author)
+	}
+	return c, nil
+}
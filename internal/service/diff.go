@@ -0,0 +1,90 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+// maxDiffCells bounds the table of the longest common subsequence, which
+// takes a word per cell: changes bigger than that are shown as the whole old
+// text replaced by the new one.
+const maxDiffCells = 1 << 20
+
+// diffLines lists the lines of a and b in order, marking those only in a as
+// removed and those only in b as added. The lines they start and end with
+// are kept as they are; the longest common subsequence of the rest is
+// quadratic, so it is only looked for while that stays small.
+func diffLines(a, b []string) []module.DiffLine {
+	var lines []module.DiffLine
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, module.DiffLine{Op: module.DiffSame, Text: a[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, module.DiffLine{Op: module.DiffSame, Text: line})
+	}
+	return lines
+}
+
+func diffMiddle(a, b []string) []module.DiffLine {
+	var lines []module.DiffLine
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, module.DiffLine{Op: module.DiffRemove, Text: line})
+		}
+		for _, line := range b {
+			lines = append(lines, module.DiffLine{Op: module.DiffAdd, Text: line})
+		}
+		return lines
+	}
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, module.DiffLine{Op: module.DiffSame, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, module.DiffLine{Op: module.DiffRemove, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, module.DiffLine{Op: module.DiffAdd, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, module.DiffLine{Op: module.DiffRemove, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, module.DiffLine{Op: module.DiffAdd, Text: b[j]})
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		// each line of the diff, "  " same, "- " removed, "+ " added
+		want []string
+	}{
+		{"a\nb\nc", "a\nb\nc", []string{"  a", "  b", "  c"}},
+		{"a\nb\nc", "a\nx\nc", []string{"  a", "- b", "+ x", "  c"}},
+		{"a\nc", "a\nb\nc", []string{"  a", "+ b", "  c"}},
+		{"a\nb\nc", "b", []string{"- a", "  b", "- c"}},
+		{"", "a", []string{"- ", "+ a"}},
+		{"a\nb\nc\nd", "b\nx\nd", []string{"- a", "  b", "- c", "+ x", "  d"}},
+		{"a\r\nb", "a\nb", []string{"  a", "  b"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, line := range diffLines(splitLines(tt.a), splitLines(tt.b)) {
+			got = append(got, diffMark(line)+line.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diffLines(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func diffMark(line module.DiffLine) string {
+	switch line.Op {
+	case module.DiffAdd:
+		return "+ "
+	case module.DiffRemove:
+		return "- "
+	}
+	return "  "
+}
+
+// Past maxDiffCells the middle is shown as replaced whole, but the lines it
+// starts and ends with are still kept.
+func TestDiffLinesLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 1100; i++ {
+		a = append(a, "old "+strings.Repeat("x", i%7))
+		b = append(b, "new "+strings.Repeat("x", i%7))
+	}
+	a = append(append([]string{"first"}, a...), "last")
+	b = append(append([]string{"first"}, b...), "last")
+	lines := diffLines(a, b)
+	if len(lines) != 2+len(a)-2+len(b)-2 {
+		t.Fatalf("%d lines, want %d", len(lines), len(a)+len(b)-2)
+	}
+	if lines[0] != (module.DiffLine{Op: module.DiffSame, Text: "first"}) || lines[len(lines)-1] != (module.DiffLine{Op: module.DiffSame, Text: "last"}) {
+		t.Errorf("first and last lines not kept: %v, %v", lines[0], lines[len(lines)-1])
+	}
+	for i, line := range lines[1 : len(lines)-1] {
+		want := module.DiffRemove
+		if i >= len(a)-2 {
+			want = module.DiffAdd
+		}
+		if line.Op != want {
+			t.Fatalf("line %d is %s, want %s", i+1, line.Op, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+const (
+	maxDrafts = 50
+	// posts can be scheduled this far ahead
+	maxSchedule = 365 * 24 * time.Hour
+)
+
+var (
+	ErrDraftNotFound      = errors.New("draft not found")
+	ErrTooManyDrafts      = fmt.Errorf("you can keep at most %d drafts, publish or delete some first", maxDrafts)
+	ErrInvalidPublishTime = errors.New("invalid publish time")
+)
+
+type Drafts interface {
+	SaveDraft(d *module.Draft) error
+	GetDraft(userID, id int) (*module.Draft, error)
+	GetDrafts(userID int) ([]module.Draft, error)
+	DeleteDraft(userID, id int) error
+	GetScheduledPosts(userID int) (module.PostList, error)
+	PublishScheduledPosts() error
+}
+
+// SaveDraft stores what the user has written so far. Drafts may be empty or
+// incomplete, only the length limits of posts apply.
+func (s *PostService) SaveDraft(d *module.Draft) error {
+	verr := &ValidationError{}
+	d.Title = checkDraftText(d.Title, "title", "Title", maxTitleRunes, verr)
+	d.Message = checkDraftText(d.Message, "message", "Message", maxMessageRunes, verr)
+	d.Tags = checkDraftText(d.Tags, "categories", "Categories", maxPostCategories*(maxCategoryRunes+1), verr)
+	if err := verr.orNil(); err != nil {
+		return err
+	}
+	d.UpdatedAt = time.Now()
+	if d.ID == 0 {
+		n, err := s.repository.CountDrafts(d.UserID)
+		if err != nil {
+			log.Println("error:service:post:SaveDraft: CountDrafts: ", err)
+			return err
+		}
+		if n >= maxDrafts {
+			return ErrTooManyDrafts
+		}
+		d.CreatedAt = d.UpdatedAt
+	}
+	if err := s.repository.SaveDraft(d); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDraftNotFound
+		}
+		log.Println("error:service:post:SaveDraft: ", err)
+		return err
+	}
+	return nil
+}
+
+func checkDraftText(s, field, label string, max int, verr *ValidationError) string {
+	if !utf8.ValidString(s) {
+		verr.add(field, ErrInvalidTypingPost, label+" is not valid UTF-8")
+		return s
+	}
+	s = normalize(s)
+	if utf8.RuneCountInString(s) > max {
+		verr.add(field, ErrInvalidTypingPost, fmt.Sprintf("%s can be at most %d characters long", label, max))
+	}
+	return s
+}
+
+func (s *PostService) GetDraft(userID, id int) (*module.Draft, error) {
+	d, err := s.repository.GetDraft(id, userID)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return nil, ErrDraftNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:GetDraft: ", err)
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *PostService) GetDrafts(userID int) ([]module.Draft, error) {
+	drafts, err := s.repository.GetDraftsByUserID(userID)
+	if err != nil {
+		log.Println("error:service:post:GetDrafts: ", err)
+		return nil, err
+	}
+	return drafts, nil
+}
+
+func (s *PostService) DeleteDraft(userID, id int) error {
+	if err := s.repository.DeleteDraft(id, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDraftNotFound
+		}
+		log.Println("error:service:post:DeleteDraft: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *PostService) GetScheduledPosts(userID int) (module.PostList, error) {
+	posts, err := s.repository.GetScheduledPosts(userID)
+	if err != nil {
+		log.Println("error:service:post:GetScheduledPosts: ", err)
+		return nil, err
+	}
+	return posts, nil
+}
+
+// PublishScheduledPosts publishes the posts whose time has come. It is run
+// every minute.
+func (s *PostService) PublishScheduledPosts() error {
+	n, err := s.repository.PublishDuePosts(time.Now())
+	if err != nil {
+		log.Println("error:service:post:PublishScheduledPosts: ", err)
+		return err
+	}
+	if n > 0 {
+		log.Println("service:post:PublishScheduledPosts: published ", n)
+	}
+	return nil
+}
+
+// checkPublishAt checks the time a post is scheduled for, if any. It is
+// moved to the local zone, the one stored times are compared in.
+func checkPublishAt(post *module.Post, now time.Time, verr *ValidationError) {
+	if post.PublishAt.IsZero() {
+		return
+	}
+	post.PublishAt = post.PublishAt.Local()
+	switch {
+	case !post.PublishAt.After(now):
+		verr.add("publish_at", ErrInvalidPublishTime, "Publish time must be in the future")
+	case post.PublishAt.After(now.Add(maxSchedule)):
+		verr.add("publish_at", ErrInvalidPublishTime, "Posts can be scheduled at most a year ahead")
+	}
+}
@@ -0,0 +1,422 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// images are decoded whole to make thumbnails, this keeps that in check
+	maxImagePixels = 6000 * 6000
+	thumbSize      = 320
+)
+
+var errBadImage = errors.New("malformed image")
+
+// imageFormats maps the content types we accept to the name image.Decode
+// gives the format, and the extension files of that type get.
+var imageFormats = map[string]struct{ name, ext string }{
+	"image/jpeg": {"jpeg", ".jpg"},
+	"image/png":  {"png", ".png"},
+	"image/gif":  {"gif", ".gif"},
+	"image/webp": {"webp", ".webp"},
+}
+
+// preparedImage is an upload that passed the checks, ready to be stored.
+type preparedImage struct {
+	data        []byte
+	contentType string
+	width       int
+	height      int
+	thumb       []byte
+	thumbType   string
+}
+
+// prepareImage checks that data is a JPEG, PNG, GIF or WebP image, judging by
+// its content rather than by what the client claims, and decodes it. The
+// image is returned without its metadata, along with a thumbnail.
+func prepareImage(data []byte) (*preparedImage, error) {
+	contentType := http.DetectContentType(data)
+	format, ok := imageFormats[contentType]
+	if !ok {
+		return nil, ErrInvalidAttachment
+	}
+	cfg, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || name != format.name {
+		return nil, ErrInvalidAttachment
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+	orientation := 1
+	switch format.name {
+	case "jpeg":
+		data, orientation, err = stripJPEG(data)
+	case "png":
+		data, err = stripPNG(data)
+	case "gif":
+		data, err = stripGIF(data)
+	case "webp":
+		data, err = stripWebP(data)
+	}
+	if err != nil {
+		return nil, ErrInvalidAttachment
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidAttachment
+	}
+	p := &preparedImage{data: data, contentType: contentType, width: cfg.Width, height: cfg.Height}
+	if orientation >= 5 {
+		p.width, p.height = p.height, p.width
+	}
+	if p.thumb, p.thumbType, err = thumbnail(img, orientation); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// thumbnail scales img down to fit in a thumbSize square and turns it
+// upright. Opaque images become JPEGs, the others PNGs to keep transparency.
+func thumbnail(img image.Image, orientation int) ([]byte, string, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbSize || h > thumbSize {
+		if w >= h {
+			w, h = thumbSize, h*thumbSize/w
+		} else {
+			w, h = w*thumbSize/h, thumbSize
+		}
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	dst = orient(dst, orientation)
+
+	var buf bytes.Buffer
+	if dst.Opaque() {
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// orient turns src upright according to an EXIF orientation, 1 to 8.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	w, h := sw, sh
+	if orientation >= 5 {
+		w, h = sh, sw
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			dx, dy := x, y
+			switch orientation {
+			case 2:
+				dx = w - 1 - x
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dy = h - 1 - y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = w-1-y, x
+			case 7:
+				dx, dy = w-1-y, h-1-x
+			case 8:
+				dx, dy = y, h-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// stripJPEG drops the APP segments that carry EXIF, XMP, IPTC and the like,
+// and comments. JFIF, Adobe and ICC profile segments stay, they change how
+// the image looks. The EXIF orientation is returned and written back in an
+// EXIF segment of its own, so photos still show upright.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errBadImage
+	}
+	orientation := 1
+	var segments [][]byte
+	p := 2
+	for {
+		if p+1 >= len(data) || data[p] != 0xFF {
+			return nil, 0, errBadImage
+		}
+		marker := data[p+1]
+		if marker == 0xFF {
+			// fill byte
+			p++
+			continue
+		}
+		if marker == 0xDA {
+			// start of scan: entropy-coded data up to the end of the image
+			segments = append(segments, data[p:])
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			segments = append(segments, data[p:p+2])
+			p += 2
+			continue
+		}
+		if p+4 > len(data) {
+			return nil, 0, errBadImage
+		}
+		end := p + 2 + int(binary.BigEndian.Uint16(data[p+2:]))
+		if end > len(data) || end < p+4 {
+			return nil, 0, errBadImage
+		}
+		segment, payload := data[p:end], data[p+4:end]
+		p = end
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			if o := exifOrientation(payload[6:]); o != 0 {
+				orientation = o
+			}
+			continue
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")), marker == 0xEE:
+			// kept
+		case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	out := []byte{0xFF, 0xD8}
+	if len(segments) > 0 && bytes.HasPrefix(segments[0], []byte{0xFF, 0xE0}) {
+		out = append(out, segments[0]...)
+		segments = segments[1:]
+	}
+	if orientation != 1 {
+		out = append(out, orientationSegment(orientation)...)
+	}
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return out, orientation, nil
+}
+
+// exifOrientation reads the orientation tag from the first IFD of an EXIF
+// block, or returns 0 when there is none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// tag 0x0112 holds one SHORT
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationSegment is an APP1 segment with an EXIF block that has the
+// orientation tag and nothing else.
+func orientationSegment(orientation int) []byte {
+	return []byte{
+		0xFF, 0xE1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0x00, 0x00,
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+}
+
+// pngChunks are the chunks that affect how a PNG or APNG looks. The rest,
+// text, time and EXIF among them, is dropped.
+var pngChunks = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true,
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true, "sBIT": true, "bKGD": true, "pHYs": true,
+	"acTL": true, "fcTL": true, "fdAT": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errBadImage
+	}
+	out := []byte(signature)
+	p := len(signature)
+	for {
+		if p+12 > len(data) {
+			return nil, errBadImage
+		}
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		end := p + 12 + length
+		if length < 0 || end > len(data) || end < p {
+			return nil, errBadImage
+		}
+		typ := string(data[p+4 : p+8])
+		if pngChunks[typ] {
+			out = append(out, data[p:end]...)
+		}
+		p = end
+		if typ == "IEND" {
+			// whatever follows the image is dropped too
+			return out, nil
+		}
+	}
+}
+
+// stripGIF drops comments and application extensions other than the one
+// that makes animations loop.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errBadImage
+	}
+	p := 13
+	if data[10]&0x80 != 0 {
+		p += 3 << (data[10]&0x07 + 1)
+	}
+	if p > len(data) {
+		return nil, errBadImage
+	}
+	out := append([]byte(nil), data[:p]...)
+	for p < len(data) {
+		start := p
+		switch data[p] {
+		case 0x21:
+			if p+2 > len(data) {
+				return nil, errBadImage
+			}
+			label := data[p+1]
+			end, err := skipGIFBlocks(data, p+2)
+			if err != nil {
+				return nil, err
+			}
+			p = end
+			keep := label == 0xF9 || label == 0x01
+			if label == 0xFF && start+14 <= len(data) {
+				app := string(data[start+3 : start+14])
+				keep = app == "NETSCAPE2.0" || app == "ANIMEXTS1.0"
+			}
+			if keep {
+				out = append(out, data[start:p]...)
+			}
+		case 0x2C:
+			p += 10
+			if p > len(data) {
+				return nil, errBadImage
+			}
+			if data[p-1]&0x80 != 0 {
+				p += 3 << (data[p-1]&0x07 + 1)
+			}
+			// LZW minimum code size, then the image data
+			end, err := skipGIFBlocks(data, p+1)
+			if err != nil {
+				return nil, err
+			}
+			p = end
+			out = append(out, data[start:p]...)
+		case 0x3B:
+			return append(out, 0x3B), nil
+		default:
+			return nil, errBadImage
+		}
+	}
+	return nil, errBadImage
+}
+
+// skipGIFBlocks returns where the data sub-blocks starting at p end.
+func skipGIFBlocks(data []byte, p int) (int, error) {
+	for {
+		if p >= len(data) {
+			return 0, errBadImage
+		}
+		n := int(data[p])
+		p += 1 + n
+		if n == 0 {
+			return p, nil
+		}
+	}
+}
+
+// webpChunks are the chunks that make up a still or animated WebP image; EXIF
+// and XMP are dropped, and with them their flags in the VP8X header.
+var webpChunks = map[string]bool{
+	"VP8 ": true, "VP8L": true, "VP8X": true, "ALPH": true, "ANIM": true, "ANMF": true, "ICCP": true,
+}
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errBadImage
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:])) + 8
+	if size < 12 || size > len(data) {
+		return nil, errBadImage
+	}
+	data = data[:size]
+	out := append([]byte(nil), data[:12]...)
+	for p := 12; p < len(data); {
+		if p+8 > len(data) {
+			return nil, errBadImage
+		}
+		typ := string(data[p : p+4])
+		length := int(binary.LittleEndian.Uint32(data[p+4:]))
+		end := p + 8 + length + length&1
+		if length < 0 || end > len(data) || end < p {
+			return nil, errBadImage
+		}
+		if webpChunks[typ] {
+			chunk := append([]byte(nil), data[p:end]...)
+			if typ == "VP8X" && length > 0 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		}
+		p = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, 4, 3), color.Palette{color.Black, color.White})
+	img.SetColorIndex(1, 1, 1)
+	return img
+}
+
+func testJPEG(t testing.TB) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// an EXIF block saying the photo is turned a quarter, and a comment
+	exif := orientationSegment(6)
+	comment := []byte{0xFF, 0xFE, 0x00, 0x07, 's', 'e', 'c', 'r', 'e'}
+	out := append([]byte{0xFF, 0xD8}, exif...)
+	out = append(out, comment...)
+	return append(out, data[2:]...)
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func testPNG(t testing.TB) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// a text chunk right after IHDR, which is 8+25 bytes in
+	out := append([]byte(nil), data[:33]...)
+	out = append(out, pngChunk("tEXt", []byte("Author\x00secret"))...)
+	out = append(out, data[33:]...)
+	return append(out, "trailing secret"...)
+}
+
+func testGIF(t testing.TB) []byte {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// a comment extension before the image descriptor, after the header,
+	// the screen descriptor and a two colour table
+	comment := []byte{0x21, 0xFE, 0x06, 's', 'e', 'c', 'r', 'e', 't', 0x00}
+	out := append([]byte(nil), data[:19]...)
+	out = append(out, comment...)
+	return append(out, data[19:]...)
+}
+
+func webpChunk(typ string, payload []byte) []byte {
+	chunk := append([]byte(typ), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// testWebP is only a container: the strippers don't look into the bitstream.
+func testWebP() []byte {
+	body := []byte("WEBP")
+	body = append(body, webpChunk("VP8X", []byte{0x0C, 0, 0, 0, 3, 0, 0, 2, 0, 0})...)
+	body = append(body, webpChunk("VP8L", []byte{0x2F, 1, 2, 3, 4})...)
+	body = append(body, webpChunk("EXIF", []byte("secret"))...)
+	body = append(body, webpChunk("XMP ", []byte("secret"))...)
+	out := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	return append(out, body...)
+}
+
+func TestStripImages(t *testing.T) {
+	tests := []struct {
+		name  string
+		strip func([]byte) ([]byte, error)
+		data  []byte
+	}{
+		{"jpeg", stripJPEGData, testJPEG(t)},
+		{"png", stripPNG, testPNG(t)},
+		{"gif", stripGIF, testGIF(t)},
+		{"webp", stripWebP, testWebP()},
+	}
+	for _, tt := range tests {
+		out, err := tt.strip(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if bytes.Contains(out, []byte("secre")) {
+			t.Errorf("%s: metadata left in %q", tt.name, out)
+		}
+		if tt.name != "webp" {
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("%s: stripped image doesn't decode: %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestStripJPEGOrientation(t *testing.T) {
+	out, orientation, err := stripJPEG(testJPEG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orientation != 6 {
+		t.Errorf("orientation = %d, want 6", orientation)
+	}
+	if !bytes.Contains(out, orientationSegment(6)) {
+		t.Error("orientation wasn't written back")
+	}
+}
+
+func TestStripWebPFlags(t *testing.T) {
+	out, err := stripWebP(testWebP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := out[20]; flags&(0x08|0x04) != 0 {
+		t.Errorf("VP8X flags = %#x, EXIF and XMP bits still set", flags)
+	}
+	if size := int(binary.LittleEndian.Uint32(out[4:])); size != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(out)-8)
+	}
+}
+
+// stripJPEGData drops the orientation so that every stripper has one shape.
+func stripJPEGData(data []byte) ([]byte, error) {
+	out, _, err := stripJPEG(data)
+	return out, err
+}
+
+func TestStripTruncated(t *testing.T) {
+	tests := []struct {
+		name  string
+		strip func([]byte) ([]byte, error)
+		data  []byte
+		// cut before this, every prefix is missing a header or a segment
+		// and must be refused
+		headers int
+	}{
+		// the JPEG scan data runs to the end, only the segments before it can be cut short
+		{"jpeg", stripJPEGData, testJPEG(t), bytes.Index(testJPEG(t), []byte{0xFF, 0xDA}) + 2},
+		{"png", stripPNG, testPNG(t), len(testPNG(t)) - len("trailing secret")},
+		{"gif", stripGIF, testGIF(t), len(testGIF(t))},
+		{"webp", stripWebP, testWebP(), len(testWebP())},
+	}
+	for _, tt := range tests {
+		for n := 0; n < len(tt.data); n++ {
+			_, err := tt.strip(tt.data[:n])
+			if n < tt.headers && err == nil {
+				t.Errorf("%s: cut to %d bytes of %d, no error", tt.name, n, len(tt.data))
+			}
+		}
+	}
+}
+
+func TestStripOversized(t *testing.T) {
+	withLength := func(data []byte, at int, length []byte) []byte {
+		out := append([]byte(nil), data...)
+		copy(out[at:], length)
+		return out
+	}
+	jpg, pngData, gifData, webp := testJPEG(t), testPNG(t), testGIF(t), testWebP()
+
+	tests := []struct {
+		name  string
+		strip func([]byte) ([]byte, error)
+		data  []byte
+	}{
+		{"jpeg segment past the end", stripJPEGData, withLength(jpg, 4, []byte{0xFF, 0xFF})},
+		{"jpeg segment shorter than its length", stripJPEGData, withLength(jpg, 4, []byte{0x00, 0x01})},
+		{"png chunk past the end", stripPNG, withLength(pngData, 33, []byte{0x00, 0x10, 0x00, 0x00})},
+		{"png chunk of 4GB", stripPNG, withLength(pngData, 33, []byte{0xFF, 0xFF, 0xFF, 0xFF})},
+		{"gif sub-block past the end", stripGIF, append(gifData[:len(gifData)-1:len(gifData)-1], 0x21, 0xFE, 0xFF, 'x')},
+		{"gif colour table past the end", stripGIF, withLength(gifData[:16], 10, []byte{0x87})},
+		{"riff size past the end", stripWebP, withLength(webp, 4, []byte{0xFF, 0xFF, 0xFF, 0x7F})},
+		{"riff size of 4GB", stripWebP, withLength(webp, 4, []byte{0xFF, 0xFF, 0xFF, 0xFF})},
+		{"webp chunk past the end", stripWebP, withLength(webp, 16, []byte{0x00, 0x10, 0x00, 0x00})},
+		{"webp chunk of 4GB", stripWebP, withLength(webp, 16, []byte{0xFF, 0xFF, 0xFF, 0xFF})},
+	}
+	for _, tt := range tests {
+		if _, err := tt.strip(tt.data); err != errBadImage {
+			t.Errorf("%s: error %v, want %v", tt.name, err, errBadImage)
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	tiff := orientationSegment(8)[10:]
+	if o := exifOrientation(tiff); o != 8 {
+		t.Errorf("orientation = %d, want 8", o)
+	}
+	for n := 0; n < len(tiff)-6; n++ {
+		if o := exifOrientation(tiff[:n]); o != 0 {
+			t.Errorf("cut to %d bytes, orientation %d", n, o)
+		}
+	}
+	// the first IFD said to be far past the block
+	far := append([]byte(nil), tiff...)
+	copy(far[4:], []byte{0xFF, 0xFF, 0xFF, 0xF0})
+	if o := exifOrientation(far); o != 0 {
+		t.Errorf("IFD past the end, orientation %d", o)
+	}
+}
+
+// FuzzStrip makes sure no input makes a stripper panic, and that what they
+// return still starts like the format.
+func FuzzStrip(f *testing.F) {
+	f.Add(testJPEG(f))
+	f.Add(testPNG(f))
+	f.Add(testGIF(f))
+	f.Add(testWebP())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if out, _, err := stripJPEG(data); err == nil && !bytes.HasPrefix(out, []byte{0xFF, 0xD8}) {
+			t.Errorf("stripJPEG returned %q", out)
+		}
+		if out, err := stripPNG(data); err == nil && !bytes.HasPrefix(out, []byte("\x89PNG\r\n\x1a\n")) {
+			t.Errorf("stripPNG returned %q", out)
+		}
+		if out, err := stripGIF(data); err == nil && (len(out) < 13 || out[len(out)-1] != 0x3B) {
+			t.Errorf("stripGIF returned %q", out)
+		}
+		if out, err := stripWebP(data); err == nil && int(binary.LittleEndian.Uint32(out[4:]))+8 != len(out) {
+			t.Errorf("stripWebP returned %q", out)
+		}
+		exifOrientation(data)
+	})
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageQuery fixes the size of the page q asks for and returns the query for
+// the repository, which reads one row more to tell whether another page
+// follows.
+func pageQuery(q module.PageQuery) (module.PageQuery, int) {
+	size := q.Limit
+	if size < 1 || size > maxPageSize {
+		size = defaultPageSize
+	}
+	q.Limit = size + 1
+	return q, size
+}
+
+// pageOf cuts rows read with pageQuery down to size and finds the cursors of
+// the pages around them.
+func pageOf[T any](rows []T, q module.PageQuery, size int, cursor func(T) module.Cursor) ([]T, module.Pagination) {
+	var p module.Pagination
+	more := len(rows) > size
+	if more {
+		if q.Before != nil {
+			// read backwards: the extra row is the first one
+			rows = rows[len(rows)-size:]
+		} else {
+			rows = rows[:size]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, p
+	}
+	first, last := cursor(rows[0]).String(), cursor(rows[len(rows)-1]).String()
+	if q.Before != nil {
+		p.Next = last
+		if more {
+			p.Prev = first
+		}
+	} else {
+		if more {
+			p.Next = last
+		}
+		if q.After != nil {
+			p.Prev = first
+		}
+	}
+	return rows, p
+}
+
+// checkSort fills in the default order of post listings, and the start of
+// the window of top posts.
+func checkSort(sort module.PostSort, now time.Time) (module.PostSort, error) {
+	if sort.By == "" {
+		sort.By = module.SortNew
+	}
+	valid := false
+	for _, by := range module.Sorts {
+		valid = valid || sort.By == by
+	}
+	if !valid {
+		return sort, ErrInvalidQueryRequest
+	}
+	sort.Since = time.Time{}
+	if sort.By != module.SortTop {
+		sort.Window = ""
+		return sort, nil
+	}
+	if sort.Window == "" {
+		sort.Window = "all"
+	}
+	window, ok := module.TopWindows[sort.Window]
+	if !ok {
+		return sort, ErrInvalidQueryRequest
+	}
+	if window > 0 {
+		sort.Since = now.Add(-window)
+	}
+	return sort, nil
+}
+
+func postCursor(p module.Post) module.Cursor {
+	return module.Cursor{Score: p.Rank, Date: p.Date, ID: p.ID}
+}
+
+func commentCursor(c module.Comment) module.Cursor {
+	return module.Cursor{Date: c.Date, ID: c.ID}
+}
+
+// maxFilterCategories is how many categories a listing can be filtered by
+// at once.
+const maxFilterCategories = 10
+
+// checkFilter turns the categories of filter into slugs and works out the times
+// its dates stand for. Personal filters need a user.
+func checkFilter(filter module.PostFilter) (module.PostFilter, error) {
+	if filter.Personal() && filter.UserID == 0 {
+		return filter, ErrInvalidQueryRequest
+	}
+	var categories []string
+	seen := make(map[string]bool)
+	for _, c := range filter.Categories {
+		c = module.Slugify(normalize(c))
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		categories = append(categories, c)
+	}
+	if len(categories) > maxFilterCategories {
+		return filter, ErrInvalidQueryRequest
+	}
+	filter.Categories = categories
+	var ok bool
+	if filter.Since, ok = parseDate(filter.From); !ok {
+		return filter, ErrInvalidQueryRequest
+	}
+	if filter.Until, ok = parseDate(filter.To); !ok {
+		return filter, ErrInvalidQueryRequest
+	}
+	if !filter.Until.IsZero() {
+		// the last day is listed too
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+		if !filter.Since.IsZero() && !filter.Since.Before(filter.Until) {
+			return filter, ErrInvalidQueryRequest
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ive663/forum/internal/config"
+	"github.com/ive663/forum/internal/module"
+)
+
+// bcrypt ignores everything after the 72nd byte
+const maxPasswordBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, p := range strings.Fields(commonPasswordList) {
+		m[p] = true
+	}
+	return m
+}()
+
+// ValidationError keeps a message for every form field that failed validation.
+// It unwraps to the first rule that failed, so errors.Is works as with plain errors.
+type ValidationError struct {
+	Fields map[string]string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	for _, field := range []string{"current", "username", "email", "password", "name", "slug", "description", "color", "title", "message", "categories", "attachments", "publish_at", "comment"} {
+		if msg, ok := e.Fields[field]; ok {
+			return msg
+		}
+	}
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) add(field string, err error, msg string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	if _, ok := e.Fields[field]; ok {
+		return
+	}
+	e.Fields[field] = msg
+	if e.Err == nil {
+		e.Err = err
+	}
+}
+
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// checkPassword applies the password policy. user is the owner of the password,
+// the password may not repeat their login or email.
+func checkPassword(policy config.Password, password string, user *module.User, verr *ValidationError) {
+	fail := func(msg string) {
+		verr.add("password", ErrInvalidPassword, msg)
+	}
+	if len([]rune(password)) < policy.MinLength {
+		fail(fmt.Sprintf("Password must be at least %d characters long", policy.MinLength))
+		return
+	}
+	if len(password) > maxPasswordBytes {
+		fail(fmt.Sprintf("Password must not be longer than %d bytes", maxPasswordBytes))
+		return
+	}
+	if classes := characterClasses(password); classes < policy.MinClasses {
+		fail(fmt.Sprintf("Password must mix at least %d of: lowercase letters, uppercase letters, digits, symbols", policy.MinClasses))
+		return
+	}
+	lower := strings.ToLower(password)
+	if user != nil {
+		if lower == strings.ToLower(user.Login) || lower == strings.ToLower(user.Email) {
+			fail("Password must not be the same as your username or email")
+			return
+		}
+		if at := strings.IndexByte(user.Email, '@'); at > 0 && lower == strings.ToLower(user.Email[:at]) {
+			fail("Password must not be the same as your username or email")
+			return
+		}
+	}
+	if commonPasswords[lower] {
+		fail("This password is too common, please choose another one")
+	}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
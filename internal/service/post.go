@@ -3,9 +3,12 @@ package service
 import (
 	"database/sql"
 	"errors"
+	"io"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/ive663/forum/internal/config"
 	"github.com/ive663/forum/internal/repository"
 
 	"github.com/ive663/forum/internal/module"
@@ -16,31 +19,42 @@ var (
 	ErrEmptyValue            = errors.New("Empty value")
 	ErrInvalidTypingPost     = errors.New("Invalid typing post")
 	ErrInvalidTypingCategory = errors.New("Invalid typing category")
+	ErrPostNotFound          = errors.New("post not found")
 )
 
 type Post interface {
-	CreatePost(post *module.Post, category []string) error
-	CreateCategory(category *module.Category) error
-	GetNewPosts() (module.PostList, error)
-	GetAllPostBy(userid int, query map[string][]string) (module.PostList, error)
+	CreatePost(post *module.Post, category []string, uploads []module.Upload) error
+	GetPosts(filter module.PostFilter, sort module.PostSort, q module.PageQuery) (module.PostList, module.Pagination, error)
 	GetPostIdByUserId(id int) (*module.Post, error)
 	GetPostByPostId(id int) (*module.Post, error)
+	EditPost(actor *module.User, post *module.Post, tags []string) error
+	DeletePost(actor *module.User, postID int) error
+	GetPostHistory(postID, from, to int) (*module.PostHistory, error)
+	OpenAttachment(actor *module.User, id int, thumb bool) (*module.Attachment, io.ReadSeekCloser, error)
+	Drafts
 
 	///  added new interfaces for likes and dislikes ///
 	GetLikesCountByPostID(postID int) (*module.Post, error)
 	GetDisLikesCountByPostID(postID int) (*module.Post, error)
 	AddLikeByPost(postID int, userID int) error
 	AddDislikeByPost(postID int, userID int) error
+	GetPostVote(postID int, userID int) (*module.Vote, error)
 	///=================///
 }
 
 type PostService struct {
 	repository repository.Post
+	categories repository.Categories
+	blobs      BlobStore
+	uploads    config.Uploads
 }
 
-func newPostService(repository repository.Post) *PostService {
+func newPostService(repository repository.Post, categories repository.Categories, blobs BlobStore, uploads config.Uploads) *PostService {
 	return &PostService{
 		repository: repository,
+		categories: categories,
+		blobs:      blobs,
+		uploads:    uploads,
 	}
 }
 
@@ -86,6 +100,19 @@ func (s *PostService) AddLikeByPost(postID int, userID int) error {
 	return nil
 }
 
+// GetPostVote returns the counters of a post and the vote userID gave it.
+func (s *PostService) GetPostVote(postID int, userID int) (*module.Vote, error) {
+	vote, err := s.repository.GetPostVote(postID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:GetPostVote: ", err)
+		return nil, err
+	}
+	return vote, nil
+}
+
 // AddDisLikeByPostID adds a dislike to a post and returns an error
 func (s *PostService) AddDislikeByPost(postID int, userID int) error {
 	if err := s.repository.PostHasDisLike(postID, userID); err == nil {
@@ -111,62 +138,69 @@ func (s *PostService) AddDislikeByPost(postID int, userID int) error {
 
 ///===============================================///
 
-func (s *PostService) CreatePost(post *module.Post, categories []string) error {
-	err := validPost(post)
+// CreatePost publishes a post in the categories, given by slug, with the
+// images attached to it. A post with a PublishAt stays hidden until then.
+func (s *PostService) CreatePost(post *module.Post, categories []string, uploads []module.Upload) error {
+	catalogue, err := s.categories.GetCatalogue()
 	if err != nil {
+		log.Println("error:service:post:CreatePost: ", err)
 		return err
 	}
-
-	id, err := s.repository.CreatePost(post)
+	categories, err = validPost(post, categories, catalogue)
 	if err != nil {
-		log.Println("error:service:post:CreatePost:", err)
 		return err
 	}
-	for _, category := range categories {
-		c := &module.Category{
-			PostID: id,
-			Tag:    category,
-		}
-
-		if err := s.repository.CreateCategory(c); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (s *PostService) CreateCategory(category *module.Category) error {
-	err := validCategory(category)
+	images, err := s.prepareUploads(uploads)
 	if err != nil {
 		return err
 	}
-	err = s.repository.CreateCategory(category)
+	attachments, err := s.storeImages(images)
 	if err != nil {
-		log.Println("error:service:post:CreateCategory:", err)
+		log.Println("error:service:post:CreatePost: storeImages: ", err)
+		return err
+	}
+
+	for i := range attachments {
+		attachments[i].Date = post.Date
+	}
+	if _, err := s.repository.CreatePost(post, categories, attachments); err != nil {
+		log.Println("error:service:post:CreatePost:", err)
+		// nothing of the post was stored, so no attachment refers to the blobs
+		removeBlobs(s.blobs, attachments)
 		return err
 	}
 	return nil
 }
 
-func (s *PostService) GetNewPosts() (module.PostList, error) {
-	posts, err := s.repository.GetNewPosts()
+func (s *PostService) GetPosts(filter module.PostFilter, sort module.PostSort, q module.PageQuery) (module.PostList, module.Pagination, error) {
+	now := time.Now()
+	sort, err := checkSort(sort, now)
 	if err != nil {
-		log.Println("error:service:post:GetNewPosts:", err)
-		return nil, err
+		return nil, module.Pagination{}, err
+	}
+	if filter, err = checkFilter(filter); err != nil {
+		return nil, module.Pagination{}, err
+	}
+	q, size := pageQuery(q)
+	posts, err := s.repository.GetPosts(filter, sort, q)
+	if err != nil {
+		log.Println("error:service:post:GetPosts:", err)
+		return nil, module.Pagination{}, err
 	}
+	posts, page := pageOf(posts, q, size, postCursor)
+	ids := make([]int, len(posts))
 	for i := range posts {
-		category, err := s.repository.GetAllCategoryByPostId(posts[i].ID)
-		likes, err := s.repository.GetLikesCountByPostID(posts[i].ID)
-		dislikes, err := s.repository.GetDisLikesCountByPostID(posts[i].ID)
-		if err != nil {
-			log.Println("error:service:post:GetNewPosts:", err)
-			return nil, err
-		}
-		posts[i].Categories = category
-		posts[i].Likes = likes.Likes
-		posts[i].Dislikes = dislikes.Dislikes
+		ids[i] = posts[i].ID
 	}
-	return posts, nil
+	categories, err := s.repository.GetCategoriesByPostIDs(ids)
+	if err != nil {
+		log.Println("error:service:post:GetPosts:", err)
+		return nil, module.Pagination{}, err
+	}
+	for i := range posts {
+		posts[i].Categories = categories[posts[i].ID]
+	}
+	return posts, page, nil
 }
 
 func (s PostService) GetPostIdByUserId(id int) (*module.Post, error) {
@@ -180,6 +214,19 @@ func (s PostService) GetPostIdByUserId(id int) (*module.Post, error) {
 
 func (s *PostService) GetPostByPostId(id int) (*module.Post, error) {
 	p, err := s.repository.GetPostByPostId(id)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:GetPostInPostId:", err)
+		return nil, err
+	}
+	p.Categories, err = s.repository.GetAllCategoryByPostId(id)
+	if err != nil {
+		log.Println("error:service:post:GetPostInPostId:", err)
+		return nil, err
+	}
+	p.Attachments, err = s.repository.GetAttachmentsByPostID(id)
 	if err != nil {
 		log.Println("error:service:post:GetPostInPostId:", err)
 		return nil, err
@@ -187,121 +234,129 @@ func (s *PostService) GetPostByPostId(id int) (*module.Post, error) {
 	return p, nil
 }
 
-func validPost(post *module.Post) error {
-	whiteSpaceTitle := true
-	for _, title := range post.Title {
-		if title < 32 || title > 127 {
-			return ErrInvalidTypingPost
-		}
-		if title != ' ' {
-			whiteSpaceTitle = false
-			break
-		}
+// EditPost replaces the title, message and categories of a post. Authors may edit
+// their own posts and moderators anyone's; deleted posts can't be edited.
+func (s *PostService) EditPost(actor *module.User, post *module.Post, categories []string) error {
+	old, err := s.GetPostByPostId(post.ID)
+	if err != nil {
+		return err
 	}
-	if whiteSpaceTitle {
-		return ErrEmptyValue
+	if old.Deleted() {
+		return ErrPostNotFound
 	}
-	whiteSpaceMessage := true
-	for _, message := range post.Message {
-		if message < 32 || message > 127 {
-			return ErrInvalidTypingPost
-		}
-		if message != ' ' {
-			whiteSpaceMessage = false
-			break
+	if !old.CanEdit(actor) {
+		return ErrForbidden
+	}
+	catalogue, err := s.categories.GetCatalogue()
+	if err != nil {
+		log.Println("error:service:post:EditPost: ", err)
+		return err
+	}
+	categories, err = validPost(post, categories, catalogue)
+	if err != nil {
+		return err
+	}
+	rev := &module.PostRevision{
+		PostID:   post.ID,
+		EditorID: actor.ID,
+		Editor:   actor.Login,
+		Title:    post.Title,
+		Message:  post.Message,
+		Tags:     categories,
+		Date:     time.Now(),
+	}
+	if err := s.repository.UpdatePost(rev); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPostNotFound
 		}
+		log.Println("error:service:post:EditPost: ", err)
+		return err
 	}
-	if whiteSpaceMessage {
-		return ErrEmptyValue
+	if actor.ID != old.AuthorID {
+		log.Printf("service:post:EditPost: %s edited post %d by %s\n", actor.Login, old.ID, old.Author)
 	}
 	return nil
 }
 
-func validCategory(category *module.Category) error {
-	whiteSpaceTag := true
-	for _, tag := range category.Tag {
-		if tag < 32 || tag > 127 {
-			return ErrInvalidTypingPost
-		}
-		if tag != ' ' {
-			whiteSpaceTag = false
-			break
+// DeletePost hides a post from everyone but moderators and deletes its
+// attachments. Like editing, it is left to the author and moderators.
+func (s *PostService) DeletePost(actor *module.User, postID int) error {
+	post, err := s.repository.GetPostByPostId(postID)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return ErrPostNotFound
+	}
+	if err != nil {
+		log.Println("error:service:post:DeletePost: GetPostByPostId: ", err)
+		return err
+	}
+	if !post.CanEdit(actor) {
+		return ErrForbidden
+	}
+	attachments, err := s.repository.DeletePost(postID, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPostNotFound
 		}
+		log.Println("error:service:post:DeletePost: ", err)
+		return err
 	}
-	if whiteSpaceTag || category.Tag == "" {
-		return ErrEmptyValue
+	removeBlobs(s.blobs, attachments)
+	if actor.ID != post.AuthorID {
+		log.Printf("service:post:DeletePost: %s removed post %d by %s\n", actor.Login, post.ID, post.Author)
 	}
 	return nil
 }
 
-func (s *PostService) GetAllPostBy(userid int, query map[string][]string) (module.PostList, error) {
-	var (
-		posts []module.Post
-		err   error
-	)
-	if userid == 0 {
-		for key, val := range query {
-			switch key {
-			case "category":
-				posts, err = s.repository.GetPostByCategory(strings.Join(val, ""))
-				if err != nil {
-					log.Println("error:post:GetPostByCategory: ", err)
-					return nil, err
-				}
-				if err != nil {
-					log.Println("error:post:GetAllPostBy:time: ", err)
-					return nil, err
-				}
-			}
-		}
+// GetPostHistory compares revision from of a post with revision to. Zero
+// picks the latest revision for to and the one before to for from.
+func (s *PostService) GetPostHistory(postID, from, to int) (*module.PostHistory, error) {
+	post, err := s.GetPostByPostId(postID)
+	if err != nil {
+		return nil, err
 	}
-	for key, val := range query {
-		switch key {
-		case "category":
-			posts, err = s.repository.GetPostByCategory(strings.Join(val, ""))
-			if err != nil {
-				if errors.Is(err, repository.ErrRecordNotFound) {
-					return nil, ErrInvalidQueryRequest
-				}
-				log.Println("error:post:GetPostByCategory: ", err)
-				return nil, err
-			}
-		case "mypost":
-			switch strings.Join(val, "") {
-			case "mypost":
-				posts, err = s.repository.GetPostsByUserId(userid)
-				if err != nil {
-					log.Println("error:post:GetAllPostBy:mypost: ", err)
-					return nil, err
-				}
-			default:
-				return nil, ErrInvalidQueryRequest
-			}
-
-		case "mylikedposts":
-			switch strings.Join(val, "") {
-			case "mylikedposts":
-				posts, err = s.repository.GetMyLikedPosts(userid)
-				if err != nil {
-					log.Println("error:post:GetAllPostBy:likedpost: ", err)
-					return nil, err
-				}
-			default:
-				return nil, ErrInvalidQueryRequest
-			}
-		default:
-			log.Println("error:post:GetAllPostBy:default: ", err)
-			return nil, ErrInvalidQueryRequest
-		}
+	revisions, err := s.repository.GetPostRevisions(postID)
+	if err != nil {
+		log.Println("error:service:post:GetPostHistory: ", err)
+		return nil, err
 	}
-	for i := range posts {
-		log.Println(posts[i].ID)
-		category, err := s.repository.GetAllCategoryByPostId(posts[i].ID)
-		if err != nil {
-			log.Println("error:post:GetAllPostBy:category: ", err)
-			return nil, err
+	h := &module.PostHistory{Post: post, Revisions: revisions}
+	if len(revisions) == 0 {
+		return h, nil
+	}
+	if to == 0 {
+		to = len(revisions)
+	}
+	if from == 0 {
+		from = to - 1
+		if from < 1 {
+			from = 1
 		}
-		posts[i].Categories = category
 	}
-	return posts, nil
+	if from < 1 || to < 1 || from > len(revisions) || to > len(revisions) {
+		return nil, ErrInvalidQueryRequest
+	}
+	for i := range revisions {
+		revisions[i].DateFormat = revisions[i].Date.Format("02.01.2006 15:04")
+	}
+	h.From, h.To = &revisions[from-1], &revisions[to-1]
+	h.Title = diffLines([]string{h.From.Title}, []string{h.To.Title})
+	h.Message = diffLines(splitLines(h.From.Message), splitLines(h.To.Message))
+	h.Tags = diffLines(h.From.Tags, h.To.Tags)
+	return h, nil
+}
+
+// validPost checks the title, message, categories and publish time of a
+// post and normalizes them in place. The slugs of the categories, picked
+// from catalogue, are returned.
+func validPost(post *module.Post, categories []string, catalogue []module.Category) ([]string, error) {
+	verr := &ValidationError{}
+	post.Title = strings.TrimSpace(checkText(post.Title, textRule{
+		field: "title", label: "Title", min: 1, max: maxTitleRunes, err: ErrInvalidTypingPost,
+	}, verr))
+	post.Message = checkText(post.Message, textRule{
+		field: "message", label: "Message", min: 1, max: maxMessageRunes, multiline: true, err: ErrInvalidTypingPost,
+	}, verr)
+	categories = checkCategories(categories, catalogue, verr)
+	checkPublishAt(post, time.Now(), verr)
+	return categories, verr.orNil()
 }
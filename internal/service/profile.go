@@ -0,0 +1,101 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+const (
+	ProfilePageSize = 10
+	maxBio          = 500
+	maxAvatarURL    = 500
+)
+
+var (
+	ErrProfileNotFound = errors.New("user not found")
+	ErrInvalidBio      = errors.New("bio must be at most 500 characters")
+	ErrInvalidAvatar   = errors.New("avatar must be an http or https link to an image")
+)
+
+type Profile interface {
+	GetProfile(login, tab string, page int) (*module.ProfilePage, error)
+	UpdateProfile(userID int, bio, avatar string) error
+}
+
+type ProfileService struct {
+	repository repository.Profile
+}
+
+func newProfileService(repository repository.Profile) *ProfileService {
+	return &ProfileService{
+		repository: repository,
+	}
+}
+
+// GetProfile loads the user with one page of their posts or comments.
+// Pages start at 1.
+func (s *ProfileService) GetProfile(login, tab string, page int) (*module.ProfilePage, error) {
+	user, err := s.repository.GetProfileByLogin(normalize(login))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProfileNotFound
+	}
+	if err != nil {
+		log.Println("error:service:profile:GetProfile: ", err)
+		return nil, err
+	}
+	stats, err := s.repository.GetUserStats(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	p := &module.ProfilePage{User: user, Stats: *stats, Tab: tab, Page: page}
+	// one extra row tells whether there is a next page
+	offset := (page - 1) * ProfilePageSize
+	switch tab {
+	case "posts":
+		posts, err := s.repository.GetPostsByAuthor(user.ID, ProfilePageSize+1, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(posts) > ProfilePageSize {
+			posts, p.HasNext = posts[:ProfilePageSize], true
+		}
+		user.Posts = module.PostList(posts).PrepToView()
+	case "comments":
+		comments, err := s.repository.GetCommentsByAuthor(user.ID, ProfilePageSize+1, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(comments) > ProfilePageSize {
+			comments, p.HasNext = comments[:ProfilePageSize], true
+		}
+		user.Comments = module.CommentList(comments).PrepToView()
+	default:
+		return nil, ErrInvalidQueryRequest
+	}
+	return p, nil
+}
+
+func (s *ProfileService) UpdateProfile(userID int, bio, avatar string) error {
+	bio = strings.TrimSpace(bio)
+	if utf8.RuneCountInString(bio) > maxBio {
+		return ErrInvalidBio
+	}
+	avatar = strings.TrimSpace(avatar)
+	if avatar != "" {
+		u, err := url.Parse(avatar)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(avatar) > maxAvatarURL {
+			return ErrInvalidAvatar
+		}
+	}
+	return s.repository.UpdateProfile(userID, bio, avatar)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+var (
+	ErrForbidden   = errors.New("you don't have permission to do that")
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// authorize returns ErrForbidden unless actor has role or a higher one.
+func authorize(actor *module.User, role string) error {
+	if !actor.HasRole(role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// SeedAdmins promotes the accounts listed in FORUM_ADMINS, so a fresh
+// installation has someone who can hand out roles.
+func (s *AuthService) SeedAdmins() error {
+	for _, login := range s.cfg.Admins {
+		n, err := s.repository.SetUserRoleByLogin(login, module.RoleAdmin)
+		if err != nil {
+			log.Println("Error:service:auth:SeedAdmins: ", err)
+			return err
+		}
+		if n > 0 {
+			log.Println("service:auth:SeedAdmins: promoted ", login)
+		}
+	}
+	return nil
+}
+
+func (s *AuthService) GetUsers(actor *module.User) ([]module.User, error) {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.repository.GetUsers()
+}
+
+// SetUserRole changes the role of another account. Admins can't change their
+// own role, so the last one can't lock everybody out.
+func (s *AuthService) SetUserRole(actor *module.User, userID int, role string) error {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return err
+	}
+	if !module.ValidRole(role) {
+		return ErrInvalidRole
+	}
+	if actor.ID == userID {
+		return ErrForbidden
+	}
+	if err := s.repository.SetUserRole(userID, role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		log.Println("Error:service:auth:SetUserRole: ", err)
+		return err
+	}
+	// the user signs in again, so no session outlives the old role
+	if err := s.sessions.DeleteByUserID(userID, ""); err != nil {
+		log.Println("Error:service:auth:SetUserRole: DeleteByUserID: ", err)
+		return err
+	}
+	log.Printf("service:auth:SetUserRole: %s made user %d %s\n", actor.Login, userID, role)
+	return nil
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+const (
+	maxSearchQuery = 200
+	maxSearchTerms = 16
+)
+
+var (
+	ErrSearchUnavailable = errors.New("Search is not available on this forum")
+	ErrEmptySearch       = errors.New("Type a word to search for")
+	ErrInvalidSearch     = errors.New("Search is too long, use at most 200 characters and 16 words")
+	ErrInvalidSearchDate = errors.New("Dates must be given as YYYY-MM-DD, the first one not after the second")
+)
+
+type Search interface {
+	Search(form module.SearchForm, size int) (*module.SearchResults, error)
+}
+
+type SearchService struct {
+	repository repository.Search
+}
+
+func newSearchService(repository repository.Search) *SearchService {
+	return &SearchService{
+		repository: repository,
+	}
+}
+
+// Search finds the posts and comments that match what the user typed, best
+// first, a page of size at a time.
+func (s *SearchService) Search(form module.SearchForm, size int) (*module.SearchResults, error) {
+	if size < 1 || size > maxPageSize {
+		size = defaultPageSize
+	}
+	if form.Page < 1 {
+		form.Page = 1
+	}
+	q, err := parseSearch(form)
+	if err != nil {
+		return nil, err
+	}
+	q.Offset, q.Limit = (form.Page-1)*size, size+1
+	results, err := s.repository.Search(q)
+	switch {
+	case errors.Is(err, repository.ErrSearchUnavailable):
+		return nil, ErrSearchUnavailable
+	case errors.Is(err, repository.ErrSearchSyntax):
+		return nil, ErrEmptySearch
+	case err != nil:
+		log.Println("error:service:search:Search: ", err)
+		return nil, err
+	}
+	page := &module.SearchResults{Page: form.Page, More: len(results) > size}
+	if page.More {
+		results = results[:size]
+	}
+	page.Results = results
+	return page, nil
+}
+
+// parseSearch turns a search into a query for the index. Words must all be
+// found; "quoted words" must be found together, -word and -"quoted words"
+// must not be, and word* matches any word that starts with it. author:name
+// and tag:category narrow the search down like the fields of the form.
+func parseSearch(form module.SearchForm) (module.SearchQuery, error) {
+	q := module.SearchQuery{
+		Author: strings.TrimSpace(form.Author),
+		Tag:    module.Slugify(normalize(form.Tag)),
+	}
+	text := normalize(form.Query)
+	if utf8.RuneCountInString(text) > maxSearchQuery {
+		return q, ErrInvalidSearch
+	}
+	var include, exclude []string
+	for _, term := range splitSearch(text) {
+		if !term.phrase && !term.exclude {
+			if name, value, ok := strings.Cut(term.text, ":"); ok && value != "" {
+				switch strings.ToLower(name) {
+				case "author":
+					q.Author = value
+					continue
+				case "tag":
+					q.Tag = module.Slugify(value)
+					continue
+				}
+			}
+		}
+		match := term.match()
+		if match == "" {
+			continue
+		}
+		if term.exclude {
+			exclude = append(exclude, match)
+		} else {
+			include = append(include, match)
+		}
+	}
+	if len(include)+len(exclude) > maxSearchTerms {
+		return q, ErrInvalidSearch
+	}
+	if len(include) == 0 {
+		return q, ErrEmptySearch
+	}
+	q.Match = "(" + strings.Join(include, " ") + ")"
+	for _, match := range exclude {
+		q.Match += " NOT " + match
+	}
+	var ok bool
+	if q.From, ok = parseDate(form.From); !ok {
+		return q, ErrInvalidSearchDate
+	}
+	if q.To, ok = parseDate(form.To); !ok {
+		return q, ErrInvalidSearchDate
+	}
+	if !q.To.IsZero() {
+		// the last day is searched too
+		q.To = q.To.AddDate(0, 0, 1)
+		if !q.From.IsZero() && !q.From.Before(q.To) {
+			return q, ErrInvalidSearchDate
+		}
+	}
+	return q, nil
+}
+
+type searchTerm struct {
+	text    string
+	phrase  bool
+	exclude bool
+}
+
+// splitSearch cuts a search into words and "quoted words". A name: keeps
+// the quoted words right after it, as in author:"two words".
+func splitSearch(text string) []searchTerm {
+	var terms []searchTerm
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			return terms
+		}
+		var term searchTerm
+		if strings.HasPrefix(text, "-") {
+			term.exclude = true
+			text = text[1:]
+		}
+		if strings.HasPrefix(text, `"`) {
+			term.phrase = true
+			text = text[1:]
+			end := strings.IndexByte(text, '"')
+			if end < 0 {
+				end = len(text)
+			}
+			term.text, text = text[:end], strings.TrimPrefix(text[end:], `"`)
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			if colon := strings.Index(text[:end], `:"`); colon >= 0 {
+				// name:"quoted words"
+				value := text[colon+2:]
+				quote := strings.IndexByte(value, '"')
+				if quote < 0 {
+					quote = len(value)
+				}
+				term.text = text[:colon+1] + value[:quote]
+				text = strings.TrimPrefix(value[quote:], `"`)
+			} else {
+				term.text, text = text[:end], text[end:]
+			}
+		}
+		terms = append(terms, term)
+	}
+}
+
+// match quotes the term for FTS5, so that none of what the user typed is
+// read as its syntax. Terms without a letter or digit match nothing and are
+// dropped.
+func (t searchTerm) match() string {
+	text := t.text
+	prefix := !t.phrase && strings.HasSuffix(text, "*")
+	text = strings.TrimRight(text, "*")
+	if strings.IndexFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+		return ""
+	}
+	match := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+	if prefix {
+		match += "*"
+	}
+	return match
+}
+
+// parseDate reads a date as a date input sends it, in the server's zone. An
+// empty value gives the zero time.
+func parseDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	return t, err == nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		query  string
+		match  string
+		author string
+		tag    string
+	}{
+		{"go", `("go")`, "", ""},
+		{"go sql", `("go" "sql")`, "", ""},
+		{`"two words" go`, `("two words" "go")`, "", ""},
+		{"go -java", `("go") NOT "java"`, "", ""},
+		{`go -"two words"`, `("go") NOT "two words"`, "", ""},
+		{"datab*", `("datab"*)`, "", ""},
+		{`"datab*"`, `("datab")`, "", ""},
+		{`say "hi`, `("say" "hi")`, "", ""},
+		{`a"b OR c NEAR(d)`, `("a""b" "OR" "c" "NEAR(d)")`, "", ""},
+		{"go author:alice", `("go")`, "alice", ""},
+		{`go author:"al ice"`, `("go")`, "al ice", ""},
+		{"go Tag:Go-Lang", `("go")`, "", "go-lang"},
+		{"go author:", `("go" "author:")`, "", ""},
+		{"go -author:bob", `("go") NOT "author:bob"`, "", ""},
+		{"go * - ---", `("go")`, "", ""},
+	}
+	for _, tt := range tests {
+		q, err := parseSearch(module.SearchForm{Query: tt.query})
+		if err != nil {
+			t.Errorf("parseSearch(%q): %v", tt.query, err)
+			continue
+		}
+		if q.Match != tt.match || q.Author != tt.author || q.Tag != tt.tag {
+			t.Errorf("parseSearch(%q) = %q, %q, %q, want %q, %q, %q", tt.query, q.Match, q.Author, q.Tag, tt.match, tt.author, tt.tag)
+		}
+	}
+}
+
+func TestParseSearchErrors(t *testing.T) {
+	tests := []struct {
+		form module.SearchForm
+		want error
+	}{
+		{module.SearchForm{}, ErrEmptySearch},
+		{module.SearchForm{Query: "  *  "}, ErrEmptySearch},
+		{module.SearchForm{Query: "-go"}, ErrEmptySearch},
+		{module.SearchForm{Query: "author:alice"}, ErrEmptySearch},
+		{module.SearchForm{Query: strings.Repeat("a", maxSearchQuery+1)}, ErrInvalidSearch},
+		{module.SearchForm{Query: strings.Repeat("a ", maxSearchTerms+1)}, ErrInvalidSearch},
+		{module.SearchForm{Query: "go", From: "2023-13-01"}, ErrInvalidSearchDate},
+		{module.SearchForm{Query: "go", To: "yesterday"}, ErrInvalidSearchDate},
+		{module.SearchForm{Query: "go", From: "2023-05-02", To: "2023-05-01"}, ErrInvalidSearchDate},
+	}
+	for _, tt := range tests {
+		if _, err := parseSearch(tt.form); err != tt.want {
+			t.Errorf("parseSearch(%+v) = %v, want %v", tt.form, err, tt.want)
+		}
+	}
+}
+
+func TestParseSearchDates(t *testing.T) {
+	q, err := parseSearch(module.SearchForm{Query: "go", From: "2023-05-01", To: "2023-05-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2023, 5, 1, 0, 0, 0, 0, time.Local)
+	if !q.From.Equal(from) || !q.To.Equal(from.AddDate(0, 0, 1)) {
+		t.Errorf("dates %v to %v, want the whole of %v", q.From, q.To, from)
+	}
+}
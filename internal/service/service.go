@@ -1,17 +1,28 @@
 package service
 
-import "github.com/ive663/forum/internal/repository"
+import (
+	"github.com/ive663/forum/internal/config"
+	"github.com/ive663/forum/internal/mailer"
+	"github.com/ive663/forum/internal/repository"
+)
 
 type Service struct {
 	Auth
 	Post
 	Comment
+	Profile
+	Search
+	Categories
 }
 
-func NewServices(repositories *repository.Repository) *Service {
+func NewServices(repositories *repository.Repository, cfg *config.Config, mailer mailer.Mailer) *Service {
+	blobs := newBlobStore(cfg.Uploads)
 	return &Service{
-		Auth:    newAuthService(repositories.Auth),
-		Post:    newPostService(repositories.Post),
-		Comment: newCommentService(repositories.Comment),
+		Auth:       newAuthService(repositories.Auth, mailer, blobs, cfg),
+		Post:       newPostService(repositories.Post, repositories.Categories, blobs, cfg.Uploads),
+		Comment:    newCommentService(repositories.Comment),
+		Profile:    newProfileService(repositories.Profile),
+		Search:     newSearchService(repositories.Search),
+		Categories: newCategoryService(repositories.Categories),
 	}
 }
@@ -0,0 +1,386 @@
+package service
+
+import (
+	"container/list"
+	"database/sql"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ive663/forum/internal/config"
+	"github.com/ive663/forum/internal/module"
+	"github.com/ive663/forum/internal/repository"
+)
+
+// SessionStore keeps the sign-in sessions. A missing session is reported as
+// sql.ErrNoRows, whatever the store is.
+type SessionStore interface {
+	Create(session *module.Session) error
+	Get(uuid string) (*module.Session, error)
+	ListByUserID(userID int) ([]module.Session, error)
+	// Touch stores the LastSeen and ExpiresAt of the session.
+	Touch(session *module.Session) error
+	// Rotate moves a session to a new uuid.
+	Rotate(uuid, newUUID string) error
+	Delete(uuid string) error
+	DeleteByID(id, userID int) error
+	// DeleteByUserID removes every session of the user except keep.
+	DeleteByUserID(userID int, keep string) error
+	DeleteExpired(now time.Time) error
+}
+
+func newSessionStore(cfg config.Sessions, repo repository.Auth) SessionStore {
+	var store SessionStore
+	switch cfg.Store {
+	case "memory":
+		store = NewMemorySessionStore()
+	case "sqlite":
+		store = &sqliteSessionStore{repo: repo}
+	default:
+		log.Printf("Error:service:newSessionStore: unknown store %q, using sqlite\n", cfg.Store)
+		store = &sqliteSessionStore{repo: repo}
+	}
+	if cfg.CacheSize > 0 {
+		return NewSessionCache(store, cfg.CacheSize)
+	}
+	return store
+}
+
+// sqliteSessionStore keeps the sessions in the sessions table.
+type sqliteSessionStore struct {
+	repo repository.Auth
+}
+
+func (s *sqliteSessionStore) Create(session *module.Session) error {
+	return s.repo.CreateNewSession(session)
+}
+
+func (s *sqliteSessionStore) Get(uuid string) (*module.Session, error) {
+	return s.repo.GetSessionByUUID(uuid)
+}
+
+func (s *sqliteSessionStore) ListByUserID(userID int) ([]module.Session, error) {
+	return s.repo.GetSessionsByUserID(userID)
+}
+
+func (s *sqliteSessionStore) Touch(session *module.Session) error {
+	return s.repo.TouchSession(session)
+}
+
+func (s *sqliteSessionStore) Rotate(uuid, newUUID string) error {
+	return s.repo.RotateSession(uuid, newUUID)
+}
+
+func (s *sqliteSessionStore) Delete(uuid string) error {
+	return s.repo.Delete(uuid)
+}
+
+func (s *sqliteSessionStore) DeleteByID(id, userID int) error {
+	return s.repo.DeleteSessionByID(id, userID)
+}
+
+func (s *sqliteSessionStore) DeleteByUserID(userID int, keep string) error {
+	return s.repo.DeleteSessionsByUserID(userID, keep)
+}
+
+func (s *sqliteSessionStore) DeleteExpired(now time.Time) error {
+	return s.repo.DeleteExpiredSession()
+}
+
+// MemorySessionStore keeps the sessions in a map. They are lost on restart,
+// so it is meant for tests and throwaway instances.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	lastID   int
+	sessions map[string]module.Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]module.Session)}
+}
+
+func (s *MemorySessionStore) Create(session *module.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	session.ID = s.lastID
+	s.sessions[session.UUID] = *session
+	return nil
+}
+
+func (s *MemorySessionStore) Get(uuid string) (*module.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[uuid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &session, nil
+}
+
+func (s *MemorySessionStore) ListByUserID(userID int) ([]module.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []module.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) Touch(session *module.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.sessions[session.UUID]; ok {
+		stored.LastSeen = session.LastSeen
+		stored.ExpiresAt = session.ExpiresAt
+		s.sessions[session.UUID] = stored
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) Rotate(uuid, newUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[uuid]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.sessions, uuid)
+	session.UUID = newUUID
+	s.sessions[newUUID] = session
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, uuid)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteByID(id, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uuid, session := range s.sessions {
+		if session.ID == id && session.UserID == userID {
+			delete(s.sessions, uuid)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *MemorySessionStore) DeleteByUserID(userID int, keep string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uuid, session := range s.sessions {
+		if session.UserID == userID && uuid != keep {
+			delete(s.sessions, uuid)
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteExpired(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uuid, session := range s.sessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.sessions, uuid)
+		}
+	}
+	return nil
+}
+
+// SessionCacheStats are the counters of a SessionCache since startup.
+type SessionCacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Size      int    `json:"size"`
+}
+
+// SessionCache keeps up to size recently used sessions in memory in front of
+// another store. An entry lives until its session expires or is deleted
+// through the cache; when the cache is full the least recently used one goes.
+type SessionCache struct {
+	store SessionStore
+	size  int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// gen changes on every deletion, so a lookup that raced with one
+	// doesn't put the deleted session back
+	gen uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func NewSessionCache(store SessionStore, size int) *SessionCache {
+	return &SessionCache{
+		store:   store,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *SessionCache) Stats() SessionCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return SessionCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+		Size:      c.size,
+	}
+}
+
+func (c *SessionCache) Create(session *module.Session) error {
+	if err := c.store.Create(session); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	gen := c.gen
+	c.mu.Unlock()
+	c.put(*session, gen)
+	return nil
+}
+
+func (c *SessionCache) Get(uuid string) (*module.Session, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[uuid]; ok {
+		session := e.Value.(module.Session)
+		if time.Now().Before(session.ExpiresAt) {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return &session, nil
+		}
+		c.remove(e)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	c.misses.Add(1)
+	session, err := c.store.Get(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Before(session.ExpiresAt) {
+		c.put(*session, gen)
+	}
+	return session, nil
+}
+
+func (c *SessionCache) ListByUserID(userID int) ([]module.Session, error) {
+	return c.store.ListByUserID(userID)
+}
+
+func (c *SessionCache) Touch(session *module.Session) error {
+	c.mu.Lock()
+	if e, ok := c.entries[session.UUID]; ok {
+		cached := e.Value.(module.Session)
+		cached.LastSeen = session.LastSeen
+		cached.ExpiresAt = session.ExpiresAt
+		e.Value = cached
+	}
+	c.mu.Unlock()
+	return c.store.Touch(session)
+}
+
+// Delete and the other deletions reach the store before the cache is
+// cleared: a lookup running meanwhile either reads the store after the
+// deletion, or sees gen change and doesn't cache what it read.
+func (c *SessionCache) Delete(uuid string) error {
+	err := c.store.Delete(uuid)
+	c.mu.Lock()
+	c.gen++
+	if e, ok := c.entries[uuid]; ok {
+		c.remove(e)
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *SessionCache) Rotate(uuid, newUUID string) error {
+	err := c.store.Rotate(uuid, newUUID)
+	c.mu.Lock()
+	c.gen++
+	if e, ok := c.entries[uuid]; ok {
+		c.remove(e)
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *SessionCache) DeleteByID(id, userID int) error {
+	err := c.store.DeleteByID(id, userID)
+	c.forget(func(s module.Session) bool { return s.ID == id && s.UserID == userID })
+	return err
+}
+
+func (c *SessionCache) DeleteByUserID(userID int, keep string) error {
+	err := c.store.DeleteByUserID(userID, keep)
+	c.forget(func(s module.Session) bool { return s.UserID == userID && s.UUID != keep })
+	return err
+}
+
+func (c *SessionCache) DeleteExpired(now time.Time) error {
+	err := c.store.DeleteExpired(now)
+	c.forget(func(s module.Session) bool { return s.ExpiresAt.Before(now) })
+	return err
+}
+
+// put caches the session unless something was deleted since gen was read.
+func (c *SessionCache) put(session module.Session, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if e, ok := c.entries[session.UUID]; ok {
+		e.Value = session
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[session.UUID] = c.lru.PushFront(session)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// forget drops the cached sessions that match. It walks the whole cache;
+// sign-outs are rare next to lookups.
+func (c *SessionCache) forget(match func(module.Session) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if match(e.Value.(module.Session)) {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+// remove must be called with mu held.
+func (c *SessionCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(module.Session).UUID)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Limits on what users write, counted in characters rather than bytes.
+const (
+	maxTitleRunes       = 150
+	maxMessageRunes     = 10000
+	maxCommentRunes     = 5000
+	maxCategoryRunes    = 50
+	maxDescriptionRunes = 300
+	maxPostCategories   = 10
+	minUsernameRunes    = 4
+	maxUsernameRunes    = 36
+)
+
+// textRule describes one field for checkText.
+type textRule struct {
+	field string
+	label string
+	min   int
+	max   int
+	// multiline lets line breaks and tabs through
+	multiline bool
+	err       error
+}
+
+// checkText normalizes s to NFC and checks it against rule. The normalized
+// text is returned, so two ways of typing the same letter are stored alike.
+func checkText(s string, rule textRule, verr *ValidationError) string {
+	if !utf8.ValidString(s) {
+		verr.add(rule.field, rule.err, rule.label+" is not valid UTF-8")
+		return s
+	}
+	s = norm.NFC.String(s)
+	if strings.TrimSpace(s) == "" {
+		verr.add(rule.field, ErrEmptyValue, rule.label+" can't be empty")
+		return s
+	}
+	if n := utf8.RuneCountInString(s); n < rule.min || n > rule.max {
+		verr.add(rule.field, rule.err, fmt.Sprintf("%s must be %d to %d characters long", rule.label, rule.min, rule.max))
+		return s
+	}
+	for _, r := range s {
+		if rule.multiline && (r == '\n' || r == '\r' || r == '\t') {
+			continue
+		}
+		if isHiddenRune(r) {
+			verr.add(rule.field, rule.err, fmt.Sprintf("%s can't contain control or invisible characters (%U)", rule.label, r))
+			return s
+		}
+	}
+	return s
+}
+
+// normalize puts s in the form checkText stores text in, for lookups.
+func normalize(s string) string {
+	return norm.NFC.String(s)
+}
+
+// isHiddenRune reports whether r doesn't show up as text: control and format
+// characters, which include zero-width spaces and the bidi overrides that can
+// make text read differently from how it is stored, and a few blank letters.
+func isHiddenRune(r rune) bool {
+	switch r {
+	case 'ᅟ', 'ᅠ', 'ㅤ', 'ﾠ', '⠀':
+		// Hangul fillers and the blank braille pattern
+		return true
+	}
+	return unicode.IsControl(r) || unicode.In(r, unicode.Cf, unicode.Co, unicode.Cs, unicode.Zl, unicode.Zp) ||
+		!unicode.In(r, unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Z)
+}
+
+// loginKey maps a username to a form that is the same for names which look
+// alike: case, accents, compatibility forms and the Cyrillic and Greek letters
+// that pass for Latin ones are all folded away. Two accounts may not share a
+// key.
+//
+// A capital I can't be told from a small l, so once case is folded i and l
+// are one letter too: "Iogin" has the key of "login".
+func loginKey(login string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(norm.NFKC.String(login)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		r = unicode.ToLower(r)
+		if r == 'i' {
+			r = 'l'
+		}
+		b.WriteRune(r)
+	}
+	return strings.ReplaceAll(b.String(), "rn", "m")
+}
+
+// confusables lists characters that look like a Latin letter, with that
+// letter in the same case, so that case is folded afterwards as for Latin
+// names. It is a small part of the Unicode confusables table, covering the
+// scripts our users write in.
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'l', '|': 'l',
+	// Cyrillic
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J', 'Ү': 'Y',
+	'Һ': 'H', 'Ӏ': 'I', 'Ԁ': 'D', 'Ԛ': 'Q', 'Ԝ': 'W',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ј': 'j', 'һ': 'h', 'ү': 'y', 'ӏ': 'l', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u', 'χ': 'x',
+}
+
+// mixesScripts reports whether login has letters from more than one of the
+// Latin, Cyrillic and Greek alphabets, the mix look-alike names are made of.
+func mixesScripts(login string) bool {
+	var seen *unicode.RangeTable
+	for _, r := range login {
+		for _, script := range []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek} {
+			if !unicode.Is(script, r) {
+				continue
+			}
+			if seen != nil && seen != script {
+				return true
+			}
+			seen = script
+		}
+	}
+	return false
+}
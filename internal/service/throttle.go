@@ -0,0 +1,163 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed sign-in attempts")
+
+const (
+	// failed attempts are forgotten after a day without new failures
+	loginFailureWindow = 24 * time.Hour
+	maxLockout         = 24 * time.Hour
+)
+
+// LockedError is returned while sign-in is locked for the login or the client address.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	wait := time.Until(e.Until).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return fmt.Sprintf("%v, try again in %v", ErrTooManyAttempts, wait)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+type loginAttemptKey struct {
+	key         string
+	maxFailures int
+}
+
+func (s *AuthService) loginAttemptKeys(login string, client module.Client) []loginAttemptKey {
+	keys := []loginAttemptKey{{"login:" + login, s.cfg.Login.MaxFailures}}
+	if client.IP != "" {
+		keys = append(keys, loginAttemptKey{"ip:" + client.IP, s.cfg.Login.MaxFailuresIP})
+	}
+	return keys
+}
+
+func (s *AuthService) checkLoginLock(keys []loginAttemptKey) error {
+	var until time.Time
+	for _, k := range keys {
+		a, err := s.repository.GetLoginAttempt(k.key)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			log.Println("Error:service:auth:checkLoginLock: GetLoginAttempt: ", err)
+			return err
+		}
+		if a.Locked() && a.LockedUntil.After(until) {
+			until = a.LockedUntil
+		}
+	}
+	if !until.IsZero() {
+		return &LockedError{Until: until}
+	}
+	return nil
+}
+
+// loginFailed counts the failure for every key and returns the error to show:
+// ErrUserNotFound, or LockedError once the attempt locked something.
+func (s *AuthService) loginFailed(keys []loginAttemptKey) error {
+	now := time.Now()
+	var until time.Time
+	for _, k := range keys {
+		failures, err := s.repository.AddLoginFailure(k.key, now, now.Add(-loginFailureWindow))
+		if err != nil {
+			log.Println("Error:service:auth:loginFailed: AddLoginFailure: ", err)
+			continue
+		}
+		if k.maxFailures <= 0 || failures < k.maxFailures {
+			continue
+		}
+		lock := lockoutFor(s.cfg.Login.Lockout, failures-k.maxFailures)
+		if err := s.repository.LockLogin(k.key, now.Add(lock)); err != nil {
+			log.Println("Error:service:auth:loginFailed: LockLogin: ", err)
+			continue
+		}
+		log.Printf("service:auth:loginFailed: %s locked for %v\n", k.key, lock)
+		if now.Add(lock).After(until) {
+			until = now.Add(lock)
+		}
+	}
+	if !until.IsZero() {
+		return &LockedError{Until: until}
+	}
+	return ErrUserNotFound
+}
+
+// guessFailed counts a wrong password or code as a failed sign-in, so that
+// they can't be guessed through other forms than the sign-in one either. It
+// returns the LockedError once the attempt locked something, err otherwise.
+func (s *AuthService) guessFailed(keys []loginAttemptKey, err error) error {
+	var locked *LockedError
+	if lerr := s.loginFailed(keys); errors.As(lerr, &locked) {
+		return lerr
+	}
+	return err
+}
+
+// loginSucceeded forgets the failures of the login. The address counter is
+// kept, otherwise an attacker could reset it by signing in to an own account.
+func (s *AuthService) loginSucceeded(keys []loginAttemptKey) {
+	if err := s.repository.DeleteLoginAttempt(keys[0].key); err != nil {
+		log.Println("Error:service:auth:loginSucceeded: DeleteLoginAttempt: ", err)
+	}
+}
+
+// lockoutFor doubles the base lockout for every failure over the limit.
+func lockoutFor(base time.Duration, over int) time.Duration {
+	lock := base
+	for i := 0; i < over && lock < maxLockout; i++ {
+		lock *= 2
+	}
+	if lock > maxLockout {
+		lock = maxLockout
+	}
+	return lock
+}
+
+func (s *AuthService) GetLoginLocks(actor *module.User) ([]module.LoginAttempt, error) {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return nil, err
+	}
+	attempts, err := s.repository.GetLoginAttempts()
+	if err != nil {
+		log.Println("Error:service:auth:GetLoginLocks: ", err)
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (s *AuthService) ClearLoginLock(actor *module.User, key string) error {
+	if err := authorize(actor, module.RoleAdmin); err != nil {
+		return err
+	}
+	if err := s.repository.DeleteLoginAttempt(key); err != nil {
+		log.Println("Error:service:auth:ClearLoginLock: ", err)
+		return err
+	}
+	log.Println("service:auth:ClearLoginLock: ", key)
+	return nil
+}
+
+func (s *AuthService) DeleteStaleLoginAttempts() error {
+	if err := s.repository.DeleteLoginAttemptsBefore(time.Now().Add(-loginFailureWindow)); err != nil {
+		log.Println("Error:service:auth:DeleteStaleLoginAttempts: ", err)
+		return err
+	}
+	return nil
+}
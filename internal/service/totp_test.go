@@ -0,0 +1,104 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the test vectors in RFC 6238,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC gives eight digits, these are the last six
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+	if got, _ := totpCode(strings.ToLower(rfc6238Secret), 1); got != "287082" {
+		t.Errorf("lower case secret gives %s", got)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("bad secret, no error")
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		c, _ := totpCode(rfc6238Secret, step)
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     int64
+		ok       bool
+	}{
+		{"current", code(current), 0, current, true},
+		{"with spaces", " " + code(current) + " ", 0, current, true},
+		{"previous", code(current - 1), 0, current - 1, true},
+		{"next", code(current + 1), 0, current + 1, true},
+		{"too old", code(current - 2), 0, 0, false},
+		{"too new", code(current + 2), 0, 0, false},
+		{"used already", code(current), current, 0, false},
+		{"after the last used", code(current + 1), current, current + 1, true},
+		{"short", code(current)[:5], 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := checkTOTP(rfc6238Secret, tt.code, now, tt.lastStep)
+		if step != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %d, %v, want %d, %v", tt.name, step, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := totpCode(secret, 1); err != nil {
+		t.Errorf("secret %q doesn't decode: %v", secret, err)
+	}
+	if uri := totpURI("al ice", secret); !strings.HasPrefix(uri, "otpauth://totp/Forum:al%20ice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("uri = %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("%d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q isn't like k3j9d-p0x2a", code)
+		}
+		if got := normalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))); got != code {
+			t.Errorf("normalizeRecoveryCode gives %q for %q", got, code)
+		}
+	}
+}
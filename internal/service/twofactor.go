@@ -0,0 +1,272 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ive663/forum/internal/module"
+)
+
+var (
+	ErrSecondFactorRequired = errors.New("enter the code from your authenticator app")
+	ErrInvalidCode          = errors.New("invalid code")
+	ErrTOTPEnabled          = errors.New("two-factor authentication is already enabled")
+	ErrTOTPDisabled         = errors.New("two-factor authentication is not enabled")
+	ErrTOTPMandatory        = errors.New("two-factor authentication is mandatory for your account")
+)
+
+const (
+	loginChallengeTTL    = 5 * time.Minute
+	maxChallengeAttempts = 5
+)
+
+// ChallengeError is returned by GenerateSessionToken when the password was
+// correct but the account also needs a code; Token identifies the sign-in.
+type ChallengeError struct {
+	Token string
+}
+
+func (e *ChallengeError) Error() string {
+	return ErrSecondFactorRequired.Error()
+}
+
+func (e *ChallengeError) Unwrap() error {
+	return ErrSecondFactorRequired
+}
+
+func (s *AuthService) newLoginChallenge(userID int, remember bool) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	c := &module.LoginChallenge{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		Remember:  remember,
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}
+	if err := s.repository.CreateLoginChallenge(c); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteSignIn creates the session for a sign-in that was waiting for a
+// TOTP or recovery code, and tells whether the user asked to be remembered.
+func (s *AuthService) CompleteSignIn(challenge, code string, client module.Client) (string, bool, error) {
+	if challenge == "" {
+		return "", false, ErrInvalidToken
+	}
+	c, err := s.repository.GetLoginChallenge(hashToken(challenge))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, ErrInvalidToken
+		}
+		log.Println("Error:service:auth:CompleteSignIn: GetLoginChallenge: ", err)
+		return "", false, err
+	}
+	if time.Now().After(c.ExpiresAt) || c.Attempts >= maxChallengeAttempts {
+		if err := s.repository.DeleteLoginChallenge(c.ID); err != nil {
+			log.Println("Error:service:auth:CompleteSignIn: DeleteLoginChallenge: ", err)
+		}
+		return "", false, ErrInvalidToken
+	}
+	user, err := s.repository.GetUserByID(c.UserID)
+	if err != nil {
+		log.Println("Error:service:auth:CompleteSignIn: GetUserByID: ", err)
+		return "", false, err
+	}
+	// a wrong code counts as a failed sign-in, so that new challenges can't
+	// be used to go on guessing
+	keys := s.loginAttemptKeys(user.Login, client)
+	if err := s.checkLoginLock(keys); err != nil {
+		return "", false, err
+	}
+	if err := s.checkSecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			if err := s.repository.AddLoginChallengeAttempt(c.ID); err != nil {
+				log.Println("Error:service:auth:CompleteSignIn: AddLoginChallengeAttempt: ", err)
+			}
+			return "", false, s.guessFailed(keys, err)
+		}
+		return "", false, err
+	}
+	if err := s.repository.DeleteLoginChallenge(c.ID); err != nil {
+		log.Println("Error:service:auth:CompleteSignIn: DeleteLoginChallenge: ", err)
+		return "", false, err
+	}
+	s.loginSucceeded(keys)
+	token, err := s.createSession(user.ID, c.Remember, client)
+	return token, c.Remember, err
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery code.
+func (s *AuthService) checkSecondFactor(user *module.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPDisabled
+	}
+	if step, ok := checkTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		return s.repository.SetTOTPLastStep(user.ID, step)
+	}
+	err := s.repository.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// TwoFactorRequired reports whether the account may not be used without two-factor authentication.
+func (s *AuthService) TwoFactorRequired(user *module.User) bool {
+	return s.cfg.Require2FA && user.HasRole(module.RoleModerator)
+}
+
+// MustEnrollTwoFactor reports whether the user has to set up two-factor
+// authentication before doing anything else.
+func (s *AuthService) MustEnrollTwoFactor(user *module.User) bool {
+	return s.TwoFactorRequired(user) && !user.TOTPEnabled
+}
+
+func (s *AuthService) GetTwoFactor(userID int) (*module.TwoFactor, error) {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:GetTwoFactor: GetUserByID: ", err)
+		return nil, err
+	}
+	tf := &module.TwoFactor{
+		Enabled:  user.TOTPEnabled,
+		Pending:  !user.TOTPEnabled && user.TOTPSecret != "",
+		Required: s.TwoFactorRequired(user),
+	}
+	if tf.Pending {
+		tf.Secret = user.TOTPSecret
+		tf.URI = totpURI(user.Login, user.TOTPSecret)
+	}
+	if tf.Enabled {
+		tf.RecoveryCodesLeft, err = s.repository.CountRecoveryCodes(userID)
+		if err != nil {
+			log.Println("Error:service:auth:GetTwoFactor: CountRecoveryCodes: ", err)
+			return nil, err
+		}
+	}
+	return tf, nil
+}
+
+// BeginTOTPEnrollment generates a new secret. It takes effect after ConfirmTOTPEnrollment.
+func (s *AuthService) BeginTOTPEnrollment(userID int) (*module.TwoFactor, error) {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:BeginTOTPEnrollment: GetUserByID: ", err)
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		log.Println("Error:service:auth:BeginTOTPEnrollment: newTOTPSecret: ", err)
+		return nil, err
+	}
+	if err := s.repository.SetTOTPSecret(userID, secret); err != nil {
+		return nil, err
+	}
+	return s.GetTwoFactor(userID)
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the app produced a
+// valid code and returns the recovery codes, which are not shown again.
+func (s *AuthService) ConfirmTOTPEnrollment(userID int, code string) ([]string, error) {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:ConfirmTOTPEnrollment: GetUserByID: ", err)
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPDisabled
+	}
+	step, ok := checkTOTP(user.TOTPSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		log.Println("Error:service:auth:ConfirmTOTPEnrollment: recoveryCodes: ", err)
+		return nil, err
+	}
+	if err := s.repository.EnableTOTP(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off. The password and code
+// are guessed under the same throttle as at sign-in.
+func (s *AuthService) DisableTOTP(userID int, password, code string, client module.Client) error {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:DisableTOTP: GetUserByID: ", err)
+		return err
+	}
+	if s.TwoFactorRequired(user) {
+		return ErrTOTPMandatory
+	}
+	keys := s.loginAttemptKeys(user.Login, client)
+	if err := s.checkLoginLock(keys); err != nil {
+		return err
+	}
+	if ComparePassword(user, password) {
+		return s.guessFailed(keys, ErrReauthFailed)
+	}
+	if err := s.checkSecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			return s.guessFailed(keys, err)
+		}
+		return err
+	}
+	s.loginSucceeded(keys)
+	return s.repository.DisableTOTP(userID)
+}
+
+func (s *AuthService) RegenerateRecoveryCodes(userID int, code string, client module.Client) ([]string, error) {
+	user, err := s.repository.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error:service:auth:RegenerateRecoveryCodes: GetUserByID: ", err)
+		return nil, err
+	}
+	keys := s.loginAttemptKeys(user.Login, client)
+	if err := s.checkLoginLock(keys); err != nil {
+		return nil, err
+	}
+	if err := s.checkSecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			return nil, s.guessFailed(keys, err)
+		}
+		return nil, err
+	}
+	s.loginSucceeded(keys)
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		log.Println("Error:service:auth:RegenerateRecoveryCodes: recoveryCodes: ", err)
+		return nil, err
+	}
+	if err := s.repository.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func recoveryCodes() (codes []string, hashes []string, err error) {
+	codes, err = newRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes = make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,72 @@
+// Saves the post being written as a draft a moment after each change, and
+// tells the server which zone the publish time is typed in.
+document.querySelectorAll('form').forEach(function (form) {
+  var draft = form.querySelector('input[name="draft"]');
+  if (!draft) {
+    return;
+  }
+  var publishAt = form.querySelector('input[name="publish_at"]');
+  var tz = form.querySelector('input[name="tz"]');
+  var timer;
+  form.addEventListener('submit', function () {
+    clearTimeout(timer);
+    if (publishAt && tz && publishAt.value) {
+      // the offset of the chosen day, which differs from today's across DST
+      tz.value = new Date(publishAt.value).getTimezoneOffset();
+    }
+  });
+  if (!window.fetch) {
+    return;
+  }
+  var status = form.querySelector('.draft-status');
+  var token = form.querySelector('input[name="csrf_token"]');
+  var fields = ['title', 'message'];
+  var saving = false;
+  var save = function () {
+    if (saving) {
+      // wait for the draft id of the first save, or a second draft is made
+      timer = setTimeout(save, 500);
+      return;
+    }
+    saving = true;
+    var body = new URLSearchParams({ draft: draft.value });
+    fields.forEach(function (name) {
+      body.append(name, form.elements[name].value);
+    });
+    form.querySelectorAll('input[name="category"]:checked').forEach(function (box) {
+      body.append('category', box.value);
+    });
+    fetch('/drafts/save', {
+      method: 'POST',
+      body: body,
+      headers: { 'X-CSRF-Token': token ? token.value : '', 'Accept': 'application/json' },
+      credentials: 'same-origin'
+    }).then(function (res) {
+      if (!res.ok) {
+        return res.text().then(function () {
+          if (status) {
+            status.textContent = 'Draft not saved (' + res.status + ')';
+          }
+        });
+      }
+      return res.json().then(function (saved) {
+        draft.value = saved.id;
+        if (status) {
+          status.textContent = 'Draft saved at ' + new Date(saved.saved_at).toLocaleTimeString();
+        }
+      });
+    }).catch(function () {}).then(function () {
+      saving = false;
+    });
+  };
+  var changed = function () {
+    clearTimeout(timer);
+    timer = setTimeout(save, 2000);
+  };
+  fields.forEach(function (name) {
+    form.elements[name].addEventListener('input', changed);
+  });
+  form.querySelectorAll('input[name="category"]').forEach(function (box) {
+    box.addEventListener('change', changed);
+  });
+});
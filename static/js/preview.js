@@ -0,0 +1,36 @@
+// Shows the message of a post form rendered as Markdown while it is typed.
+// The server renders it, so the preview matches the published post.
+document.querySelectorAll('form').forEach(function (form) {
+  var message = form.querySelector('textarea[name="message"]');
+  var preview = document.querySelector('.preview');
+  if (!message || !preview || !window.fetch) {
+    return;
+  }
+  var token = form.querySelector('input[name="csrf_token"]');
+  var timer;
+  var render = function () {
+    if (message.value.trim() === '') {
+      preview.innerHTML = '';
+      return;
+    }
+    fetch('/preview', {
+      method: 'POST',
+      body: new URLSearchParams({ message: message.value }),
+      headers: { 'X-CSRF-Token': token ? token.value : '' },
+      credentials: 'same-origin'
+    }).then(function (res) {
+      if (!res.ok) {
+        return;
+      }
+      return res.text().then(function (html) {
+        // already sanitized by the server
+        preview.innerHTML = html;
+      });
+    }).catch(function () {});
+  };
+  message.addEventListener('input', function () {
+    clearTimeout(timer);
+    timer = setTimeout(render, 300);
+  });
+  render();
+});
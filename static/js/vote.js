@@ -0,0 +1,41 @@
+// Sends vote forms in the background and updates the counters in place.
+// Without JavaScript the forms are submitted normally and redirect back.
+document.querySelectorAll('form.vote').forEach(function (form) {
+  form.addEventListener('submit', function (event) {
+    var button = event.submitter;
+    if (!window.fetch || !button) {
+      return;
+    }
+    event.preventDefault();
+    var data = new FormData(form);
+    data.set(button.name, button.value);
+    var submit = function () {
+      var input = document.createElement('input');
+      input.type = 'hidden';
+      input.name = button.name;
+      input.value = button.value;
+      form.appendChild(input);
+      form.submit();
+    };
+    fetch(form.action, {
+      method: 'POST',
+      body: new URLSearchParams(data),
+      headers: { 'Accept': 'application/json' },
+      credentials: 'same-origin'
+    }).then(function (res) {
+      var type = res.headers.get('Content-Type') || '';
+      if (!res.ok || type.indexOf('application/json') === -1) {
+        // Signed out, unverified or failed: let the server show why.
+        submit();
+        return;
+      }
+      return res.json().then(function (vote) {
+        form.querySelector('.likes').textContent = vote.likes;
+        form.querySelector('.dislikes').textContent = vote.dislikes;
+        form.querySelectorAll('.vote-btn').forEach(function (b) {
+          b.classList.toggle('voted', b.value === vote.vote);
+        });
+      });
+    }).catch(submit);
+  });
+});